@@ -0,0 +1,122 @@
+package clobber
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheFileName is the conventional name of the hash cache, kept in the scanned directory.
+const CacheFileName = ".cache-clobber-cache.json"
+
+// How long after a file is modified its size and modification time are trusted to identify its contents,
+// longer than the modification time granularity of any filesystem, so a file written twice in one tick is hashed again.
+const cacheGranularity = 2 * time.Second
+
+// Hashes of assets from earlier runs, trusted while an asset's size and modification time are unchanged.
+// Paths are slash separated and relative to the scanned directory.
+type hashCache struct {
+	Settings string                `json:"settings"` // hash and integrity settings the entries were made with
+	Written  int64                 `json:"written"`  // unix nanoseconds when the cache was written
+	Files    map[string]cacheEntry `json:"files"`
+	baseDir  string
+	previous map[string]cacheEntry // entries read, Files only holds the assets of this run
+}
+
+type cacheEntry struct {
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"modTime"` // unix nanoseconds
+	Hash      string `json:"hash"`    // cc hash
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// Reads the cache at cacheFile, which is empty when there is no such file, it can not be parsed or it was made with other settings.
+func readCache(fsys FS, baseDir, cacheFile string, opts Options, h hasher) (*hashCache, error) {
+	settings := fmt.Sprintf("%s %s %d %s", h.algorithmOrDefault(), h.encodingOrDefault(), h.length, opts.SRI)
+	c := &hashCache{Settings: settings, Files: make(map[string]cacheEntry), baseDir: baseDir}
+	b, err := fsys.ReadFile(cacheFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	read := &hashCache{}
+	if json.Unmarshal(b, read) != nil || read.Settings != settings {
+		return c, nil // rebuilt from scratch
+	}
+	c.Written = read.Written
+	c.previous = read.Files
+	return c, nil
+}
+
+// Returns the hashed asset cached for the file at path, whose stat is info.
+func (c *hashCache) lookup(path string, info os.FileInfo) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	rel, err := relSlashPath(c.baseDir, path)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	entry, exists := c.previous[rel]
+	modTime := info.ModTime().UnixNano()
+	if !exists || entry.Size != info.Size() || entry.ModTime != modTime || modTime > c.Written-int64(cacheGranularity) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Records the hashed asset at path, whose stat is info.
+func (c *hashCache) store(path string, info os.FileInfo, asset *hashedAsset) {
+	if c == nil {
+		return
+	}
+	rel, err := relSlashPath(c.baseDir, path)
+	if err != nil {
+		return
+	}
+	c.Files[rel] = cacheEntry{
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Hash:      asset.ccHash,
+		Integrity: asset.integrity,
+	}
+}
+
+// Moves the entries of renamed files to where they were renamed to, renames keep the size and modification time.
+func (c *hashCache) renamed(renames []Rename) {
+	moved := make(map[string]cacheEntry)
+	for _, job := range renames {
+		from, errFrom := relSlashPath(c.baseDir, job.From)
+		to, errTo := relSlashPath(c.baseDir, job.To)
+		if entry, exists := c.Files[from]; exists && errFrom == nil && errTo == nil {
+			delete(c.Files, from)
+			moved[to] = entry
+		}
+	}
+	for to, entry := range moved {
+		c.Files[to] = entry
+	}
+}
+
+// Keeps the entries read of assets this run did not hash, for a run limited to part of the scanned directory.
+func (c *hashCache) keepPrevious() {
+	for rel, entry := range c.previous {
+		if _, exists := c.Files[rel]; !exists {
+			c.Files[rel] = entry
+		}
+	}
+}
+
+func (c *hashCache) write(fsys FS, cacheFile string) error {
+	c.Written = time.Now().UnixNano()
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Clean(cacheFile), append(b, '\n'))
+}
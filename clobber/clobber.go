@@ -0,0 +1,388 @@
+// Package clobber renames js and css files with a hash of their contents and rewrites the html referencing them.
+package clobber
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Options of a Run. The zero value hashes with crc32 printed in decimal, renaming files in place.
+type Options struct {
+	Dir          string   // directory to scan recursively for html files, the working directory when empty
+	DryRun       bool     // plan every rename and html edit, but leave the files untouched
+	Manifest     string   // path to write the json manifest to, none when empty
+	OutDir       string   // directory to mirror Dir into, renames in place when empty
+	Journal      string   // path of the journal to record renames in, none when empty
+	Include      []string // globs of the html files and assets to select, everything when empty
+	Exclude      []string // globs of the html files, assets and directories to skip, added to those in IgnoreFileName
+	Rules        []Rule   // html references to hash, DefaultRules when nil
+	Hash         string   // hash algorithm, one of sha256, sha1, md5, crc32 or fnv64, crc32 when empty
+	HashEncoding string   // hash encoding, one of decimal, hex, base32 or base64url, decimal when empty
+	HashLength   int      // characters of the encoded hash kept, all when 0
+	LegacyHashes []string // algorithm/encoding of earlier runs, e.g. crc32/hex, whose untruncated hashes are recognised as such
+	NameTemplate string   // names of hashed files, see DefaultNameTemplate, which is used when empty
+	Keep         int      // newest generations of each hashed asset Prune keeps, referenced or not
+	Jobs         int      // files read, hashed or written at once, one per cpu when 0
+	Cache        string   // path of the cache of asset hashes from earlier runs, none when empty
+	SRI          string   // integrity algorithm for script and link tags, one of sha256, sha384 or sha512, none when empty
+	CrossOrigin  string   // crossorigin value added alongside integrity, none when empty
+	FS           FS       // filesystem every path is read from and written to, the operating system's when nil
+}
+
+// Validate returns an error for the first invalid option, every option Run checks is checked before anything is read.
+func (opts Options) Validate() error {
+	_, _, _, err := opts.prepare()
+	return err
+}
+
+// Validates opts, and returns the hasher and name template of its naming options, and Dir, "." when empty.
+func (opts Options) prepare() (hasher, nameTemplate, string, error) {
+	h, err := newHasher(opts.Hash, opts.HashEncoding, opts.HashLength, opts.LegacyHashes)
+	if err != nil {
+		return hasher{}, nameTemplate{}, "", err
+	}
+	names, err := newNameTemplate(opts.NameTemplate, h)
+	if err != nil {
+		return hasher{}, nameTemplate{}, "", err
+	}
+	if _, exists := sriAlgorithms[opts.SRI]; opts.SRI != "" && !exists {
+		return hasher{}, nameTemplate{}, "", fmt.Errorf("unknown integrity algorithm %q, want one of sha256, sha384 or sha512", opts.SRI)
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := normalizePattern(pattern); err != nil {
+			return hasher{}, nameTemplate{}, "", err
+		}
+	}
+	if opts.Keep < 0 {
+		return hasher{}, nameTemplate{}, "", fmt.Errorf("keep %d is negative", opts.Keep)
+	}
+	if opts.Jobs < 0 {
+		return hasher{}, nameTemplate{}, "", fmt.Errorf("jobs %d is negative", opts.Jobs)
+	}
+	for i, rule := range opts.Rules {
+		if rule.Tag == "" || rule.Attr == "" {
+			return hasher{}, nameTemplate{}, "", fmt.Errorf("rule %d needs both a tag and an attribute", i+1)
+		}
+	}
+	baseDir := opts.Dir
+	if baseDir == "" {
+		baseDir = "."
+	}
+	return h, names, baseDir, nil
+}
+
+func (opts Options) fs() FS {
+	if opts.FS == nil {
+		return OSFS{}
+	}
+	return opts.FS
+}
+
+// Result of a Run, Restore or Prune.
+type Result struct {
+	Edits   map[string][]Edit  // [htmlFile]edits
+	Errors  map[string][]Error // [htmlFile]errors, errors not tied to a file are under ""
+	Renames []Rename           // renames done, or planned on a dry run
+	Copied  []string           // files copied unchanged into Options.OutDir, or to copy on a dry run
+	Removed []string           // stale hashed files removed by Prune, or to remove on a dry run
+	Diffs   map[string]string  // [htmlFile]unified diff, only filled on a dry run
+	DryRun  bool
+
+	writes   []htmlWrite       // html writes done, or planned on a dry run
+	outPaths map[string]string // [path]where it is written in Options.OutDir, empty in place
+}
+
+// Edit of a reference in an html or css file.
+type Edit struct {
+	From     string // path of the referenced file
+	To       string // hashed file name it now references
+	HTMLFile string
+}
+
+// Error while hashing the references of an html or css file.
+type Error struct {
+	Err      error
+	HTMLFile string
+}
+
+func (e Error) Error() string {
+	if e.HTMLFile == "" {
+		return e.Err.Error()
+	}
+	return e.HTMLFile + ": " + e.Err.Error()
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Rename of an asset to its hashed name.
+type Rename struct {
+	From      string
+	To        string
+	HTMLFile  string // an html or css file referencing the asset
+	Hash      string // cc hash in To
+	Integrity string // subresource integrity value of the asset, none unless Options.SRI is set
+}
+
+func newResult() *Result {
+	return &Result{
+		Edits:    make(map[string][]Edit),
+		Errors:   make(map[string][]Error),
+		Diffs:    make(map[string]string),
+		outPaths: make(map[string]string),
+	}
+}
+
+func (r *Result) addEdit(htmlFile, nameFrom, nameTo string) {
+	if _, exists := r.Edits[htmlFile]; !exists {
+		r.Edits[htmlFile] = make([]Edit, 0, 1)
+	}
+	arr := r.Edits[htmlFile]
+	arr = append(arr, Edit{
+		From:     nameFrom,
+		To:       nameTo,
+		HTMLFile: htmlFile,
+	})
+	r.Edits[htmlFile] = arr
+}
+
+func (r *Result) addError(htmlFile string, err error) {
+	if _, exists := r.Errors[htmlFile]; !exists {
+		r.Errors[htmlFile] = make([]Error, 0, 1)
+	}
+	arr := r.Errors[htmlFile]
+	arr = append(arr, Error{
+		Err:      err,
+		HTMLFile: htmlFile,
+	})
+	r.Errors[htmlFile] = arr
+}
+
+func (r *Result) errorCount() int {
+	count := 0
+	for _, arr := range r.Errors {
+		count += len(arr)
+	}
+	return count
+}
+
+// Print writes every edit, removal and error in r to w, and on a dry run the planned renames, removals and diffs first.
+func (r *Result) Print(w io.Writer) {
+	if r.DryRun {
+		r.printDryRun(w)
+	}
+	if len(r.Edits) == 0 && len(r.Removed) == 0 {
+		fmt.Fprintln(w, "No changes.")
+	}
+	if !r.DryRun {
+		for _, path := range r.Removed {
+			fmt.Fprintf(w, "\nremoved %s", path)
+		}
+	}
+	for html, arr := range r.Edits {
+		if len(arr) == 0 {
+			fmt.Fprintln(w)
+			continue
+		}
+		for _, edit := range arr {
+			_, fFrom := filepath.Split(edit.From)
+			_, fTo := filepath.Split(edit.To)
+			fmt.Fprintf(w, "\n[%s] %s => %s", html, fFrom, fTo)
+		}
+	}
+
+	for html, arr := range r.Errors {
+		if len(arr) == 0 {
+			fmt.Fprintln(w)
+			continue
+		}
+		for _, edit := range arr {
+			fmt.Fprintf(w, "\n[%s] ERROR: %s", html, edit.Err.Error())
+		}
+	}
+}
+
+func (r *Result) printDryRun(w io.Writer) {
+	fmt.Fprintln(w, "Dry run, no files were changed.")
+	for _, job := range r.Renames {
+		if outPath, exists := r.outPaths[job.To]; exists {
+			fmt.Fprintf(w, "copy %s => %s\n", job.From, outPath)
+		} else {
+			fmt.Fprintf(w, "rename %s => %s\n", job.From, job.To)
+		}
+	}
+	for _, path := range r.Copied {
+		fmt.Fprintf(w, "copy %s => %s\n", path, r.outPaths[path])
+	}
+	for _, path := range r.Removed {
+		fmt.Fprintf(w, "remove %s\n", path)
+	}
+
+	htmlFiles := make([]string, 0, len(r.Diffs))
+	for html := range r.Diffs {
+		htmlFiles = append(htmlFiles, html)
+	}
+	sort.Strings(htmlFiles)
+	for _, html := range htmlFiles {
+		fmt.Fprint(w, r.Diffs[html])
+	}
+}
+
+// Run hashes every js and css file referenced by the html files under opts.Dir, and rewrites the references to them.
+// The returned error is for invalid options or a cancelled ctx, errors with single files are in Result.Errors.
+// Once files start being renamed a run is no longer cancelled, so the tree is never left half renamed.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	result, _, err := runScoped(ctx, opts, nil)
+	return result, err
+}
+
+// Part of Dir a run is limited to, as Watch does after its first run.
+type runScope struct {
+	htmlFiles []string        // html files whose references are hashed
+	copy      map[string]bool // [clean path]other files copied to OutDir
+}
+
+// Does a Run, limited to scope unless it is nil, and also returns the graph of the files it scanned.
+// A limited run adds to the cache and manifest instead of replacing them.
+func runScoped(ctx context.Context, opts Options, scope *runScope) (*Result, *assetGraph, error) {
+	h, names, baseDir, err := opts.prepare()
+	if err != nil {
+		return nil, nil, err
+	}
+	fsys := opts.fs()
+
+	result := newResult()
+	result.DryRun = opts.DryRun
+
+	filter, err := newPathFilter(fsys, baseDir, opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	var htmlFiles []string
+	if scope != nil {
+		htmlFiles = scope.htmlFiles
+	} else {
+		htmlFiles, err = htmlFilePaths(fsys, baseDir, opts.OutDir, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = DefaultRules
+	}
+	graph := newAssetGraph(fsys, filter, rules)
+	err = graph.addHTMLFiles(ctx, result, htmlFiles, opts.workers())
+	if err != nil {
+		return nil, nil, err
+	}
+	var cache *hashCache
+	if opts.Cache != "" {
+		cache, err = readCache(fsys, baseDir, opts.Cache, opts, h)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	editJobs, err := graph.jobs(ctx, result, opts, h, names, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	copyRest := func(path string, info os.FileInfo) bool {
+		if info.Name() == CacheFileName || opts.Cache != "" && samePath(path, opts.Cache) {
+			return false // the output is the same with or without a cache
+		}
+		return scope == nil || scope.copy[filepath.Clean(path)]
+	}
+	if opts.DryRun {
+		planAll(result, fsys, baseDir, opts.OutDir, editJobs, graph.scanned, copyRest)
+		return result, graph, nil
+	}
+	hashedRoot := baseDir // where the hashed files end up
+	if opts.OutDir != "" {
+		copyAll(result, fsys, baseDir, opts.OutDir, editJobs, graph.scanned, opts.workers(), copyRest)
+		hashedRoot = opts.OutDir
+	} else {
+		renameAll(result, fsys, editJobs, graph.scanned, opts.workers())
+		if opts.Journal != "" {
+			err := recordJournal(result, fsys, baseDir, opts.Journal)
+			if err != nil {
+				result.addError("", err)
+			}
+		}
+		if cache != nil {
+			cache.renamed(result.Renames)
+		}
+	}
+	if cache != nil {
+		if scope != nil {
+			cache.keepPrevious()
+		}
+		err := cache.write(fsys, opts.Cache)
+		if err != nil {
+			result.addError("", err)
+		}
+	}
+	if opts.Manifest != "" {
+		err := writeManifest(result, fsys, baseDir, hashedRoot, opts.Manifest, names, h, scope != nil)
+		if err != nil {
+			result.addError("", err)
+		}
+	}
+	return result, graph, nil
+}
+
+// Returns path relative to baseDir, slash separated.
+func relSlashPath(baseDir, path string) (string, error) {
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func appendUnique(arr []string, s string) []string {
+	for _, v := range arr {
+		if v == s {
+			return arr
+		}
+	}
+	return append(arr, s)
+}
+
+// Returns every html file under baseDir selected by filter, skipping the directory skipDir when it is not empty.
+func htmlFilePaths(fsys FS, baseDir, skipDir string, filter *pathFilter) ([]string, error) {
+	var htmlFilePaths []string
+	err := fsys.Walk(baseDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && (skipDir != "" && samePath(path, skipDir) || filter.excludedDir(path)) {
+				return filepath.SkipDir
+			}
+			if info.IsDir() || !filter.selected(path) {
+				return nil
+			}
+
+			if isHTMLFile(path) {
+				htmlFilePaths = append(htmlFilePaths, path)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, nil
+	}
+	return htmlFilePaths, nil
+}
+
+func isHTMLFile(filePath string) bool {
+	ext := filepath.Ext(filePath)
+	return ext == ".html" || ext == ".htm"
+}
@@ -0,0 +1,1385 @@
+package clobber
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	defer func() {
+		//cleanTestDirectory(t)
+	}()
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+
+	// all files that can be changed, kept for ease of use
+	var allFiles = []string{
+		"test/lame.js",
+		"test/cooler.js",
+		"test/cool.js",
+		"test/already-hashed-cc123.js",
+		"test/weird-ccna-name.js",
+		"test/weird-ccna-name-already-hashed-cc123.js",
+		"test/assets/big.js",
+		"test/assets/bloat.js",
+		"test/styles.css",
+		"test/more-styles.css",
+		"test/assets/pretty-styles.css",
+		"test/assets/ugly-styles.css",
+		"test/assets/single-quotes.js",
+	}
+
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	baseDir := "./test"
+	{
+		expectedChangedFiles := allFiles
+		result := run(t, Options{Dir: baseDir})
+		leftoverChanges, leftoverExpected := deleteMatches(allChangesToOneSlice(result), expectedChangedFiles)
+		for _, changeNotDone := range leftoverExpected {
+			t.Error("expected file to change, but it did not:", changeNotDone)
+		}
+		for _, changeDone := range leftoverChanges {
+			t.Error("did not specify file to change, but it did:", changeDone)
+		}
+		if len(result.Errors) != 0 {
+			for _, v := range result.Errors {
+				for _, vv := range v {
+					t.Error("encountered error", vv.Err)
+				}
+			}
+		}
+	}
+
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	baseDir = "./"
+	{
+		expectedChangedFiles := allFiles
+		result := run(t, Options{Dir: baseDir})
+		leftoverChanges, leftoverExpected := deleteMatches(allChangesToOneSlice(result), expectedChangedFiles)
+		for _, changeNotDone := range leftoverExpected {
+			t.Error("expected file to change, but it did not:", changeNotDone)
+		}
+		for _, changeDone := range leftoverChanges {
+			t.Error("did not specify file to change, but it did:", changeDone)
+		}
+		if len(result.Errors) != 0 {
+			for _, v := range result.Errors {
+				for _, vv := range v {
+					t.Error("encountered error", vv.Err)
+				}
+			}
+		}
+	}
+
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	baseDir = "./test/assets"
+	{
+		expectedChangedFiles := []string{
+			"lame.js",
+			"cooler.js",
+			"cool.js",
+			"pretty-styles.css",
+			"ugly-styles.css",
+		}
+		result := run(t, Options{Dir: baseDir})
+
+		leftoverChanges, leftoverExpected := deleteMatches(allChangesToOneSlice(result), expectedChangedFiles)
+		for _, changeNotDone := range leftoverExpected {
+			t.Error("expected file to change, but it did not:", changeNotDone)
+		}
+		for _, changeDone := range leftoverChanges {
+			t.Error("did not specify file to change, but it did:", changeDone)
+		}
+		if len(result.Errors) != 0 {
+			for _, v := range result.Errors {
+				for _, vv := range v {
+					t.Error("encountered error", vv.Err)
+				}
+			}
+		}
+	}
+}
+
+func TestRunRollback(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	before, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renames run sorted by path, so this is the last one and every other has completed when it fails
+	b, err := ioutil.ReadFile("./test/weird-ccna-name.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedName := nameTemplate{}.hashedName("", "./test/weird-ccna-name.js", "cc"+hasher{}.sum(b), hasher{})
+	err = os.MkdirAll("./test/"+hashedName+"/blocker", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := run(t, Options{Dir: "./test"})
+	if len(result.Errors) == 0 {
+		t.Fatal("expected the rename onto a directory to fail")
+	}
+	if len(result.Edits) != 0 || len(result.Renames) != 0 {
+		t.Errorf("expected no result after rolling back, actual %d edits and %d renames", len(result.Edits), len(result.Renames))
+	}
+
+	after, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("test/index.html was not restored")
+	}
+	for _, f := range []string{"cool.js", "styles.css", "assets/big.js", "weird-ccna-name.js"} {
+		if _, err := os.Stat("./test/" + f); err != nil {
+			t.Error("rename was not rolled back:", f)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	journalFile := "./test/" + JournalFileName
+	originals := make(map[string]string)
+	for _, f := range []string{"index.html", "assets/markup.html", "already-hashed-cc123.js"} {
+		b, err := ioutil.ReadFile("./test/" + f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		originals[f] = string(b)
+	}
+
+	result := run(t, Options{Dir: "./test", Journal: journalFile})
+	if len(result.Errors) != 0 {
+		t.Fatal("first run errored")
+	}
+	err := ioutil.WriteFile(result.Renames[0].To, []byte(`console.log("changed")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = run(t, Options{Dir: "./test", Journal: journalFile})
+	if len(result.Errors) != 0 {
+		t.Fatal("second run errored")
+	}
+	j, err := readJournal(OSFS{}, journalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Runs) != 2 {
+		t.Fatalf("expected 2 runs in the journal, actual %d", len(j.Runs))
+	}
+
+	result, err = Restore(context.Background(), Options{Dir: "./test", Journal: journalFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range result.Errors {
+		for _, vv := range v {
+			t.Error("encountered error", vv.Err)
+		}
+	}
+	for f, original := range originals {
+		b, err := ioutil.ReadFile("./test/" + f)
+		if err != nil {
+			t.Error("not restored:", f)
+			continue
+		}
+		if f != "already-hashed-cc123.js" && string(b) != original {
+			t.Errorf("expected %s restored to its original contents, actual:\n%s", f, b)
+		}
+	}
+	if _, err := os.Stat("./test/cool.js"); err != nil {
+		t.Error("not restored: cool.js")
+	}
+	if _, err := os.Stat(journalFile); err == nil {
+		t.Error("expected the journal to be removed once every run is restored")
+	}
+}
+
+func TestRestoreRetry(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	journalFile := "./test/" + JournalFileName
+	before, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := run(t, Options{Dir: "./test", Journal: journalFile})
+	if len(result.Errors) != 0 {
+		t.Fatal("run errored")
+	}
+
+	fsys := failingFS{FS: OSFS{}, failWrite: "test/index.html"}
+	result, err = Restore(context.Background(), Options{Dir: "./test", Journal: journalFile, FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors["test/index.html"]) == 0 {
+		t.Fatal("expected the failing write of index.html to be reported")
+	}
+
+	result, err = Restore(context.Background(), Options{Dir: "./test", Journal: journalFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range result.Errors {
+		for _, vv := range v {
+			t.Error("retry encountered error", vv.Err)
+		}
+	}
+	after, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("expected the retry to restore index.html, actual:\n%s", after)
+	}
+	if _, err := os.Stat("./test/cool.js"); err != nil {
+		t.Error("not restored: cool.js")
+	}
+	if _, err := os.Stat(journalFile); err == nil {
+		t.Error("expected the journal to be removed once the retry restored every run")
+	}
+}
+
+// FS failing every write of one file.
+type failingFS struct {
+	FS
+	failWrite string
+}
+
+func (f failingFS) WriteFile(name string, b []byte) error {
+	if filepath.Clean(name) == filepath.Clean(f.failWrite) {
+		return errors.New("write failed")
+	}
+	return f.FS.WriteFile(name, b)
+}
+
+func TestRunCSS(t *testing.T) {
+	cleanTestDirectory(t)
+	files := map[string]string{
+		"test/index.html":    `<link rel="stylesheet" href="css/site.css">`,
+		"test/css/site.css":  `@import "base.css"; /* url(missing.png) */ body{background:url(../img/bg.png)} @font-face{src:url('../fonts/a.woff2?v=1') format("woff2"), url(data:font/woff;base64,AAA)}`,
+		"test/css/base.css":  `h1{background:url( "../img/bg.png" )}`,
+		"test/img/bg.png":    `not really a png`,
+		"test/fonts/a.woff2": `not really a font`,
+	}
+	for path, content := range files {
+		err := OSFS{}.WriteFile(path, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := run(t, Options{Dir: "./test"})
+	for _, v := range result.Errors {
+		for _, vv := range v {
+			t.Error("encountered error", vv.Err)
+		}
+	}
+	if len(result.Renames) != 4 {
+		t.Fatalf("expected 4 renames, actual %d", len(result.Renames))
+	}
+
+	renamed := make(map[string]string)
+	for _, job := range result.Renames {
+		renamed[filepath.ToSlash(job.From)] = filepath.ToSlash(job.To)
+	}
+	site, err := ioutil.ReadFile(renamed["test/css/site.css"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bg := filepath.Split(renamed["test/img/bg.png"])
+	_, font := filepath.Split(renamed["test/fonts/a.woff2"])
+	_, base := filepath.Split(renamed["test/css/base.css"])
+	for _, ref := range []string{`@import "` + base + `"`, `url(../img/` + bg + `)`, `url('../fonts/` + font + `?v=1')`, `/* url(missing.png) */`, `url(data:font/woff;base64,AAA)`} {
+		if !strings.Contains(string(site), ref) {
+			t.Errorf("expected site.css to contain %s:\n%s", ref, site)
+		}
+	}
+
+	// the css hash covers its rewritten contents, so hashing it again result nothing
+	b, err := ioutil.ReadFile(renamed["test/css/site.css"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := nameTemplate{}.hashedName("", renamed["test/css/site.css"], "cc"+hasher{}.sum(b), hasher{})
+	if _, renamedSite := filepath.Split(renamed["test/css/site.css"]); name != renamedSite {
+		t.Errorf("expected site.css to be hashed after its references were rewritten, %s != %s", name, renamedSite)
+	}
+}
+
+func TestRunDependencyOrder(t *testing.T) {
+	cleanTestDirectory(t)
+	files := map[string]string{
+		"test/index.html": `<link rel="stylesheet" href="a.css"><link rel="stylesheet" href="c.css">`,
+		"test/a.css":      `@import "b.css";`,
+		"test/b.css":      `@import url(a.css);`,
+		"test/c.css":      `body{background:url(img.png)}`,
+		"test/img.png":    `first`,
+	}
+	for path, content := range files {
+		err := OSFS{}.WriteFile(path, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	renamedCSS := func() string {
+		result := run(t, Options{Dir: "./test", DryRun: true})
+		cycles := 0
+		for _, v := range result.Errors {
+			for _, vv := range v {
+				if !strings.Contains(vv.Err.Error(), "reference cycle") {
+					t.Error("encountered error", vv.Err)
+				}
+				cycles++
+			}
+		}
+		if cycles != 1 {
+			t.Errorf("expected the a.css and b.css cycle reported once, actual %d errors", cycles)
+		}
+		renamed := ""
+		for _, job := range result.Renames {
+			switch filepath.Base(job.From) {
+			case "c.css":
+				renamed = job.To
+			case "a.css", "b.css":
+				t.Error("expected files on a cycle to not be hashed:", job.From)
+			}
+		}
+		return renamed
+	}
+
+	before := renamedCSS()
+	err := ioutil.WriteFile("./test/img.png", []byte(`second`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := renamedCSS()
+	if before == "" || before == after {
+		t.Errorf("expected the hash of c.css to change with the image it references, %s then %s", before, after)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	before, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := run(t, Options{Dir: "./test", DryRun: true})
+	if len(result.Errors) != 0 {
+		for _, v := range result.Errors {
+			for _, vv := range v {
+				t.Error("encountered error", vv.Err)
+			}
+		}
+	}
+	if len(result.Edits) != 2 {
+		t.Errorf("expected edits planned for 2 html files, actual %d", len(result.Edits))
+	}
+	if len(result.Renames) != 13 {
+		t.Errorf("expected 13 planned renames, actual %d", len(result.Renames))
+	}
+	for _, job := range result.Renames {
+		if _, err := os.Stat(job.From); err != nil {
+			t.Error("dry run renamed file:", job.From)
+		}
+		if _, err := os.Stat(job.To); err == nil {
+			t.Error("dry run created file:", job.To)
+		}
+	}
+
+	after, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("dry run changed test/index.html")
+	}
+	diff := result.Diffs[filepath.Join("test", "index.html")]
+	if !strings.Contains(diff, `-			<script src="cool.js"></script>`) || !strings.Contains(diff, `+			<script src="cool-cc`) {
+		t.Errorf("diff of test/index.html is missing the cool.js edit:\n%s", diff)
+	}
+}
+
+func TestRunManifest(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+
+	result := run(t, Options{Dir: "./test", Manifest: "./test/manifest.json"})
+	for _, v := range result.Errors {
+		for _, vv := range v {
+			t.Error("encountered error", vv.Err)
+		}
+	}
+
+	b, err := ioutil.ReadFile("./test/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 13 {
+		t.Errorf("expected 13 manifest entries, actual %d", len(m))
+	}
+
+	entry, exists := m["cool.js"]
+	if !exists {
+		t.Fatal("manifest is missing cool.js")
+	}
+	if entry.Path != "cool-"+entry.Hash+".js" {
+		t.Errorf("expected cool.js to map to cool-%s.js, actual %s", entry.Hash, entry.Path)
+	}
+	if entry.Size != int64(len(`console.log("cool and good")`)) {
+		t.Errorf("expected cool.js size %d, actual %d", len(`console.log("cool and good")`), entry.Size)
+	}
+	if len(entry.HTML) != 2 || entry.HTML[0] != "assets/markup.html" || entry.HTML[1] != "index.html" {
+		t.Errorf("expected cool.js referenced by assets/markup.html and index.html, actual %v", entry.HTML)
+	}
+	if _, exists := m["assets/big.js"]; !exists {
+		t.Error("manifest is missing assets/big.js")
+	}
+}
+
+func TestRunManifestTwice(t *testing.T) {
+	cleanTestDirectory(t)
+	err := os.MkdirAll("./test/js", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{"./test/index.html": `<script src="js/app.js"></script>`, "./test/js/app.js": `console.log("first")`} {
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	readEntry := func() ManifestEntry {
+		b, err := ioutil.ReadFile("./test/manifest.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := Manifest{}
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, exists := m["js/app.js"]
+		if len(m) != 1 || !exists {
+			t.Fatalf("expected the manifest keyed by js/app.js alone, actual %v", m)
+		}
+		return entry
+	}
+
+	run(t, Options{Dir: "./test", Manifest: "./test/manifest.json"})
+	first := readEntry()
+	err = ioutil.WriteFile("./test/"+first.Path, []byte(`console.log("second")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second run hashes js/app-cc….js, which is still js/app.js in the manifest
+	run(t, Options{Dir: "./test", Manifest: "./test/manifest.json"})
+	second := readEntry()
+	if second.Path == first.Path || !strings.HasPrefix(second.Path, "js/app-cc") {
+		t.Errorf("expected js/app.js to map to a new hashed path, actual %s after %s", second.Path, first.Path)
+	}
+}
+
+func TestRunOutDir(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+	err := ioutil.WriteFile("./test/robots.txt", []byte("User-agent: *"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ { // second run must not pick up the html already in dist
+		result := run(t, Options{Dir: "./test", OutDir: "./test/dist"})
+		for _, v := range result.Errors {
+			for _, vv := range v {
+				t.Error("encountered error", vv.Err)
+			}
+		}
+		if len(result.Renames) != 13 {
+			t.Errorf("expected 13 hashed copies, actual %d", len(result.Renames))
+		}
+	}
+
+	after, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("out dir mode changed test/index.html")
+	}
+	if _, err := os.Stat("./test/cool.js"); err != nil {
+		t.Error("out dir mode renamed test/cool.js")
+	}
+
+	b, err := ioutil.ReadFile("./test/cool.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedName := nameTemplate{}.hashedName("", "./test/cool.js", "cc"+hasher{}.sum(b), hasher{})
+	if _, err := os.Stat("./test/dist/" + hashedName); err != nil {
+		t.Error("expected hashed copy in dist:", hashedName)
+	}
+	if _, err := os.Stat("./test/dist/cool.js"); err != nil {
+		t.Error("expected the original cool.js in dist too, for references the rules do not select")
+	}
+	if _, err := os.Stat("./test/dist/robots.txt"); err != nil {
+		t.Error("expected untouched copy of robots.txt in dist")
+	}
+	if _, err := os.Stat("./test/dist/dist"); err == nil {
+		t.Error("dist was copied into itself")
+	}
+
+	html, err := ioutil.ReadFile("./test/dist/assets/markup.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), `<script src="../`+hashedName+`"></script>`) {
+		t.Errorf("expected dist/assets/markup.html to reference %s:\n%s", hashedName, html)
+	}
+}
+
+func TestRunOutDirDryRun(t *testing.T) {
+	cleanTestDirectory(t)
+	err := os.MkdirAll("./test/js", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{"./test/index.html": `<script src="js/app.js"></script>`, "./test/js/app.js": `console.log("app")`, "./test/robots.txt": "User-agent: *"} {
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := run(t, Options{Dir: "./test", OutDir: "./test/dist", DryRun: true})
+	expectedCopies := []string{filepath.Join("test", "js", "app.js"), filepath.Join("test", "robots.txt")}
+	if len(result.Renames) != 1 || !reflect.DeepEqual(result.Copied, expectedCopies) {
+		t.Fatalf("expected js/app.js hashed, it and robots.txt copied, actual renames %v, copies %v", result.Renames, result.Copied)
+	}
+	hashed := filepath.Join("test", "dist", "js", filepath.Base(result.Renames[0].To))
+	if result.outPaths[result.Renames[0].To] != hashed {
+		t.Errorf("expected js/app.js hashed into %s, actual %s", hashed, result.outPaths[result.Renames[0].To])
+	}
+	if result.outPaths[filepath.Join("test", "robots.txt")] != filepath.Join("test", "dist", "robots.txt") {
+		t.Errorf("expected robots.txt copied into dist, actual %s", result.outPaths[filepath.Join("test", "robots.txt")])
+	}
+	diff := result.Diffs[filepath.Join("test", "index.html")]
+	if !strings.HasPrefix(diff, "--- "+filepath.Join("test", "index.html")+"\n+++ "+filepath.Join("test", "dist", "index.html")+"\n") {
+		t.Errorf("expected the diff against dist/index.html, actual:\n%s", diff)
+	}
+	if _, err := os.Stat("./test/dist"); err == nil {
+		t.Error("dry run wrote to dist")
+	}
+}
+
+func TestRunUnselectedReference(t *testing.T) {
+	files := func() *MemFS {
+		return NewMemFS(map[string][]byte{
+			"site/index.html":    []byte(`<link rel="stylesheet" href="css/site.css"><img src="img/a.png">`),
+			"site/css/site.css":  []byte(`body{background:url(../img/a.png)}`),
+			"site/img/a.png":     []byte("png"),
+			"site/img/other.png": []byte("other"),
+		})
+	}
+
+	// in place, the image img[src] still references is left unhashed, and so is the css url to it
+	fsys := files()
+	result := run(t, Options{Dir: "site", FS: fsys})
+	if result.errorCount() != 0 {
+		t.Fatalf("expected no errors, actual %v", result.Errors)
+	}
+	if _, err := fsys.Stat("site/img/a.png"); err != nil {
+		t.Error("renamed img/a.png, which index.html references through img[src]")
+	}
+	if len(result.Renames) != 1 || !strings.HasPrefix(result.Renames[0].To, filepath.Join("site", "css", "site-cc")) {
+		t.Errorf("expected css/site.css renamed alone, actual %v", result.Renames)
+	}
+
+	// into -out, the image is hashed for the css and its original copied for the html
+	fsys = files()
+	result = run(t, Options{Dir: "site", OutDir: "dist", FS: fsys})
+	if result.errorCount() != 0 {
+		t.Fatalf("expected no errors, actual %v", result.Errors)
+	}
+	if len(result.Renames) != 2 {
+		t.Errorf("expected img/a.png and css/site.css hashed, actual %v", result.Renames)
+	}
+	for _, name := range []string{"dist/img/a.png", "dist/css/site.css", "dist/img/other.png"} {
+		if _, err := fsys.Stat(name); err != nil {
+			t.Errorf("expected %s in the output", name)
+		}
+	}
+}
+
+func TestRunSRI(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+
+	result := run(t, Options{Dir: "./test", SRI: "sha384", CrossOrigin: "anonymous"})
+	for _, v := range result.Errors {
+		for _, vv := range v {
+			t.Error("encountered error", vv.Err)
+		}
+	}
+	b, err := ioutil.ReadFile("./test/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ti := range tagsFromHTML(string(b)) {
+		if _, hashed, _ := splitCCHash(filepath.Base(ti.attrs["src"].value+ti.attrs["href"].value), hasher{}); hashed == "" {
+			continue
+		}
+		integrity := ti.attrs["integrity"].value
+		if !strings.HasPrefix(integrity, "sha384-") || len(integrity) != len("sha384-")+64 {
+			t.Errorf("expected a sha384 integrity on %s", ti.wholeTag)
+		}
+		if ti.attrs["crossorigin"].value != "anonymous" {
+			t.Errorf("expected crossorigin on %s", ti.wholeTag)
+		}
+	}
+}
+
+func TestApplyJobs(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		integrity   string
+		crossOrigin string
+		expected    string
+	}{
+		{name: "rename", in: `<script src="./a.js"></script>`, expected: `<script src="./a-cc1.js"></script>`},
+		{name: "add integrity", in: `<script src="a.js"></script>`, integrity: "sha384-x", expected: `<script src="a-cc1.js" integrity="sha384-x"></script>`},
+		{name: "update integrity", in: `<script integrity='sha384-old' src="a.js"></script>`, integrity: "sha384-x", expected: `<script integrity='sha384-x' src="a-cc1.js"></script>`},
+		{name: "valueless integrity", in: `<script integrity src="a.js"></script>`, integrity: "sha384-x", expected: `<script integrity="sha384-x" src="a-cc1.js"></script>`},
+		{name: "self closing", in: `<link href="a.js"/>`, integrity: "sha384-x", crossOrigin: "anonymous", expected: `<link href="a-cc1.js" integrity="sha384-x" crossorigin="anonymous"/>`},
+		{name: "keep crossorigin", in: `<script src="a.js" crossorigin="use-credentials">`, integrity: "sha384-x", crossOrigin: "anonymous", expected: `<script src="a-cc1.js" crossorigin="use-credentials" integrity="sha384-x">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ti := tagsFromHTML(tt.in)[0]
+			attr := ti.attrs["src"]
+			if _, exists := ti.attrs["href"]; exists {
+				attr = ti.attrs["href"]
+			}
+			tagPath, _ := filepath.Split(attr.value)
+			j := &job{
+				renameTo:    "a-cc1.js",
+				tagPath:     tagPath,
+				wholeTag:    ti.wholeTag,
+				startTag:    ti.startTag,
+				valStart:    attr.valStart,
+				valEnd:      attr.valEnd,
+				attrs:       ti.attrs,
+				selfClosing: ti.selfClosing,
+				integrity:   tt.integrity,
+				crossOrigin: tt.crossOrigin,
+			}
+			if got := applyJobs(tt.in, []*job{j}); got != tt.expected {
+				t.Errorf("applyJobs() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func allChangesToOneSlice(result *Result) []Edit {
+	edits := []Edit{}
+	for _, html := range result.Edits {
+		for _, g := range html {
+			edits = append(edits, g)
+		}
+	}
+	return edits
+}
+
+func deleteMatches(a []Edit, b []string) ([]string, []string) {
+	bMap := make(map[string]struct{})
+	for _, v := range b {
+		bMap[v] = struct{}{}
+	}
+	bMapCopy := make(map[string]struct{})
+	for k, v := range bMap {
+		bMapCopy[k] = v
+	}
+
+	aMap := make(map[Edit]struct{})
+	for _, v := range a {
+		aMap[v] = struct{}{}
+	}
+	aMapCopy := make(map[Edit]struct{})
+	for k, v := range aMap {
+		aMapCopy[k] = v
+	}
+
+	// reduce aMap
+	for chg, _ := range aMap {
+		for b := range bMapCopy {
+			_, chgFName := filepath.Split(chg.From)
+			_, bFName := filepath.Split(b)
+			if chgFName == bFName {
+				delete(aMap, chg)
+				delete(bMap, b)
+			}
+		}
+	}
+
+	// reduce bMap
+	for chg, _ := range aMapCopy {
+		for b := range bMap {
+			_, chgFName := filepath.Split(chg.From)
+			_, bFName := filepath.Split(b)
+			if chgFName == bFName {
+				delete(aMap, chg)
+				delete(bMap, b)
+			}
+		}
+	}
+
+	shouldHaveChanged := []string{}
+	for v, _ := range bMap {
+		shouldHaveChanged = append(shouldHaveChanged, v)
+	}
+
+	changedButWasNotToldToChange := []string{}
+	for v, _ := range aMap {
+		changedButWasNotToldToChange = append(changedButWasNotToldToChange, v.From)
+	}
+	return changedButWasNotToldToChange, shouldHaveChanged
+}
+
+func cleanTestDirectory(t *testing.T) {
+	err := os.RemoveAll("./test")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestDirFiles(t *testing.T) {
+	err := os.RemoveAll("./test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll("./test/assets", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/already-hashed-cc123.js", []byte(`console.log("cool and AMAZING")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/weird-ccna-name.js", []byte(`console.log("cool and FANTASTIC")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/weird-ccna-name-already-hashed-cc123.js", []byte(`console.log("cool and alright alright alright")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/cool.js", []byte(`console.log("cool and good")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/cooler.js", []byte(`console.log("cooler and good")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/lame.js", []byte(`console.log("lame and good")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/styles.css", []byte(`h1{font-size:12px;}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/more-styles.css", []byte(`body{background-color:red;}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/assets/pretty-styles.css", []byte(`h2{font-size:20px;}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/assets/ugly-styles.css", []byte(`h3{font-size:30px;}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/assets/big.js", []byte(`console.log("big and good")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/assets/bloat.js", []byte(`console.log("bloat and good")`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile("./test/assets/single-quotes.js", []byte(`console.log('lyin\' is a sin')`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/index.html", []byte(`
+		<!DOCTYPE html>
+		<html lang="en">
+		<head>
+			<meta charset="UTF-8">
+			<title>Title</title>
+			<link rel="stylesheet" href="styles.css">
+			<link rel="stylesheet" href="more-styles.css">
+			<script src="cool.js"></script>
+			<script src="cool.js"></script>
+			<script src="./assets/single-quotes.js"></script>
+			<script> console.log("I belong to no one.") </script>
+			<script> if (a<b && b>c) { document.write('<script src="missing.js"></script>') } </script>
+			<!-- <script src="commented-out.js"></script> -->
+			<script src="https://code.jquery.com/jquery-3.5.1.min.js"></script>
+			<script src="./cooler.js"></script>
+			<script src="already-hashed-cc123.js"></script>
+
+			<script src="./weird-ccna-name.js"></script>
+			<script src="./weird-ccna-name-already-hashed-cc123.js"></script>
+
+			<link href="https://fonts.googleapis.com/css?family=Bowlby+One+SC|Cabin&display=swap" rel="stylesheet">
+			<link rel='icon' type='image/png' href='./favicon.png'>
+
+			<script src="assets/bloat.js"></script>
+			<script src="./assets/big.js"></script>
+			<link rel="stylesheet" href="assets/pretty-styles.css">
+			<link rel="stylesheet" href="./assets/ugly-styles.css">
+		</head>
+		<body>
+			<h1>Fear</h1>
+			<h2>The</h2>
+			<h3>Cache Clobber</h3>
+		</body>
+		</html>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("./test/assets/markup.html", []byte(`
+		<!DOCTYPE html>
+		<html lang="en">
+		<head>
+			<meta charset="UTF-8">
+			<title>Title</title>
+			<script src="../lame.js"></script>
+			<script src="../cooler.js"></script>
+			<script src="../cool.js"></script>
+			<link rel="stylesheet" href="./pretty-styles.css">
+			<link rel="stylesheet" href="../assets/ugly-styles.css">
+		</head>
+		<body>
+			<h1>Fear</h1>
+			<h2>The</h2>
+			<h3>Cache Clobber</h3>
+		</body>
+		</html>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMemFS(t *testing.T) {
+	fsys, err := LoadFS(fstest.MapFS{
+		"site/index.html":      {Data: []byte(`<link rel="stylesheet" href="css/site.css"><script src="app.js"></script>`)},
+		"site/app.js":          {Data: []byte(`console.log("in memory")`)},
+		"site/css/site.css":    {Data: []byte(`body{background:url("../img/bg.png")}`)},
+		"site/img/bg.png":      {Data: []byte("png")},
+		"site/img/unused.webp": {Data: []byte("webp")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := run(t, Options{Dir: "site", FS: fsys, Journal: "site/" + JournalFileName})
+	if len(result.Renames) != 3 {
+		t.Errorf("expected 3 renames, actual %v", result.Renames)
+	}
+	for _, job := range result.Renames {
+		if _, err := fsys.Stat(job.From); err == nil {
+			t.Errorf("expected %s to be renamed", job.From)
+		}
+		if _, err := fsys.Stat(job.To); err != nil {
+			t.Errorf("expected %s to exist: %v", job.To, err)
+		}
+	}
+	if _, err := os.Stat("site"); err == nil {
+		t.Error("run on a MemFS wrote to disk")
+	}
+
+	result, err = Restore(context.Background(), Options{Dir: "site", FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.errorCount() != 0 {
+		t.Errorf("expected no restore errors, actual %v", result.Errors)
+	}
+	b, err := fsys.ReadFile("site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<link rel="stylesheet" href="css/site.css"><script src="app.js"></script>`; string(b) != expected {
+		t.Errorf("restored html: expected %q, actual %q", expected, b)
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"site/" + IgnoreFileName:           []byte("node_modules\n"),
+		"site/index.html":                  []byte(`<script src="app.js"></script><script src="vendor/lib.js"></script>`),
+		"site/app.js":                      []byte("app"),
+		"site/vendor/lib.js":               []byte("lib"),
+		"site/node_modules/pkg/index.html": []byte(`<script src="pkg.js"></script>`),
+		"site/node_modules/pkg/pkg.js":     []byte("pkg"),
+	})
+
+	result := run(t, Options{Dir: "site", FS: fsys, Exclude: []string{"vendor/**"}})
+	if len(result.Renames) != 1 || result.Renames[0].From != filepath.Join("site", "app.js") {
+		t.Errorf("expected only site/app.js to be renamed, actual %v", result.Renames)
+	}
+	for _, path := range []string{"site/vendor/lib.js", "site/node_modules/pkg/pkg.js"} {
+		if _, err := fsys.Stat(path); err != nil {
+			t.Errorf("expected excluded %s to be left alone: %v", path, err)
+		}
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"site/index.html":   []byte(`<link rel="icon" href="icon.png"><img src="img/logo.svg"><script src="app.mjs"></script>`),
+		"site/icon.png":     []byte("png"),
+		"site/img/logo.svg": []byte("svg"),
+		"site/app.mjs":      []byte("mjs"),
+	})
+
+	result := run(t, Options{Dir: "site", FS: fsys, Rules: ExtendedRules, SRI: "sha256"})
+	if len(result.Renames) != 3 {
+		t.Errorf("expected 3 renames, actual %v", result.Renames)
+	}
+	b, err := fsys.ReadFile("site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "integrity=") != 1 {
+		t.Errorf("expected only the script to get an integrity attribute, actual %s", b)
+	}
+
+	result = run(t, Options{Dir: "site", FS: fsys})
+	if len(result.Edits) != 0 {
+		t.Errorf("expected the default rules to leave images and modules alone, actual %v", result.Edits)
+	}
+}
+
+func TestRunSrcset(t *testing.T) {
+	html := `<picture><source srcset="img/a.webp 1x,
+		img/b.webp 2x" sizes="(max-width: 600px) 480px, 800px"><img src="img/a.png" srcset="img/a.png 1x, img/b.png 2x, https://cdn.example.com/c.png 3x"></picture>`
+	fsys := NewMemFS(map[string][]byte{
+		"site/index.html": []byte(html),
+		"site/img/a.webp": []byte("a.webp"),
+		"site/img/b.webp": []byte("b.webp"),
+		"site/img/a.png":  []byte("a.png"),
+		"site/img/b.png":  []byte("b.png"),
+	})
+
+	result := run(t, Options{Dir: "site", FS: fsys, Rules: ExtendedRules, Journal: "site/" + JournalFileName})
+	renamed := make(map[string]string)
+	for _, job := range result.Renames {
+		renamed[filepath.ToSlash(job.From)] = filepath.Base(job.To)
+	}
+	if len(renamed) != 4 {
+		t.Fatalf("expected 4 renames, actual %v", result.Renames)
+	}
+	expected := `<picture><source srcset="img/` + renamed["site/img/a.webp"] + ` 1x,
+		img/` + renamed["site/img/b.webp"] + ` 2x" sizes="(max-width: 600px) 480px, 800px"><img src="img/` + renamed["site/img/a.png"] + `" srcset="img/` + renamed["site/img/a.png"] + ` 1x, img/` + renamed["site/img/b.png"] + ` 2x, https://cdn.example.com/c.png 3x"></picture>`
+	b, err := fsys.ReadFile("site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, b)
+	}
+
+	_, err = Restore(context.Background(), Options{Dir: "site", FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = fsys.ReadFile("site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != html {
+		t.Errorf("expected restore to put back\n%s\nactual\n%s", html, b)
+	}
+}
+
+func TestRunNameTemplate(t *testing.T) {
+	html := `<link rel="stylesheet" href="css/site.css"><script src="./js/app.js"></script>`
+	css := `body{background:url("../img/bg.png")}`
+	fsys := NewMemFS(map[string][]byte{
+		"site/index.html":   []byte(html),
+		"site/css/site.css": []byte(css),
+		"site/img/bg.png":   []byte("png"),
+		"site/js/app.js":    []byte("app"),
+	})
+	opts := Options{Dir: "site", FS: fsys, NameTemplate: "{hash}/{name}{ext}", Journal: "site/" + JournalFileName}
+
+	result := run(t, opts)
+	renamed := make(map[string]string) // [original]hashed, relative to site
+	for _, job := range result.Renames {
+		from, _ := relSlashPath("site", job.From)
+		to, _ := relSlashPath("site", job.To)
+		renamed[from] = to
+	}
+	for _, from := range []string{"css/site.css", "img/bg.png", "js/app.js"} {
+		if !strings.HasPrefix(renamed[from], filepath.Dir(from)+"/cc") {
+			t.Errorf("expected %s to be hashed into a directory, actual %q", from, renamed[from])
+		}
+	}
+	b, err := fsys.ReadFile("site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<link rel="stylesheet" href="` + renamed["css/site.css"] + `"><script src="` + renamed["js/app.js"] + `"></script>`
+	if string(b) != expected {
+		t.Errorf("expected html\n%s\nactual\n%s", expected, b)
+	}
+	b, err = fsys.ReadFile("site/" + renamed["css/site.css"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `body{background:url("../../` + renamed["img/bg.png"] + `")}`; string(b) != expected {
+		t.Errorf("expected css references relative to its hash directory\n%s\nactual\n%s", expected, b)
+	}
+
+	err = fsys.WriteFile("site/"+renamed["js/app.js"], []byte("app changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = run(t, opts)
+	if len(result.Renames) != 3 {
+		t.Fatalf("expected every asset to be planned again, actual %v", result.Renames)
+	}
+	for _, job := range result.Renames {
+		to, _ := relSlashPath("site", job.To)
+		if strings.Count(to, "/cc") != 1 {
+			t.Errorf("expected %s to be re-hashed, not hashed twice, actual %s", job.From, to)
+		}
+	}
+
+	_, err = Restore(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{"site/index.html": html, "site/css/site.css": css, "site/js/app.js": "app changed"} {
+		b, err := fsys.ReadFile(path)
+		if err != nil || string(b) != content {
+			t.Errorf("expected restore to put back %s as %q, actual %q, %v", path, content, b, err)
+		}
+	}
+}
+
+func TestRunHashSettingsChanged(t *testing.T) {
+	cleanTestDirectory(t)
+	content := []byte(`console.log("app")`)
+	for path, content := range map[string][]byte{"test/index.html": []byte(`<script src="app.js"></script>`), "test/app.js": content} {
+		err := OSFS{}.WriteFile(path, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each run renames the file the run before hashed, replacing its hash
+	for _, h := range []hasher{{algorithm: "sha256", encoding: "hex"}, {}, {algorithm: "md5", encoding: "base64url"}, {algorithm: "fnv64", encoding: "hex"}, {algorithm: "sha1", encoding: "hex"}, {}} {
+		result := run(t, Options{Dir: "./test", Hash: h.algorithm, HashEncoding: h.encoding})
+		if result.errorCount() != 0 || len(result.Renames) != 1 {
+			t.Fatalf("%+v: expected a single rename, actual %v, errors %v", h, result.Renames, result.Errors)
+		}
+		want := filepath.Join("test", "app-cc"+h.sum(content)+".js")
+		if result.Renames[0].To != want {
+			t.Errorf("%+v: expected %s renamed to %s, actual %s", h, result.Renames[0].From, want, result.Renames[0].To)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	cleanTestDirectory(t)
+	createTestDirFiles(t)
+
+	for _, opts := range []Options{
+		{Dir: "./test", Hash: "sha512"},
+		{Dir: "./test", HashEncoding: "base16"},
+		{Dir: "./test", SRI: "md5"},
+	} {
+		if _, err := Run(context.Background(), opts); err == nil {
+			t.Errorf("Run(%+v): expected an invalid options error", opts)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, Options{Dir: "./test"}); err != context.Canceled {
+		t.Errorf("Run with a cancelled context: expected %v, actual %v", context.Canceled, err)
+	}
+	if _, err := os.Stat("./test/cool.js"); err != nil {
+		t.Error("cancelled run renamed test/cool.js")
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	files := map[string][]byte{
+		"site/css/site.css": []byte(`body{background:url("../img/bg.png")}`),
+		"site/img/bg.png":   []byte("png"),
+	}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("site/page%d.html", i)] = []byte(fmt.Sprintf(`<link rel="stylesheet" href="css/site.css"><script src="js/%d.js"></script><script src="js/shared.js"></script>`, i%5))
+	}
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("site/js/%d.js", i)] = []byte(fmt.Sprint(i))
+	}
+	files["site/js/shared.js"] = []byte("shared")
+
+	var want map[string][]byte
+	for _, jobs := range []int{1, 8} {
+		fsys := &countingFS{FS: NewMemFS(files), reads: make(map[string]int)}
+		result := run(t, Options{Dir: "site", FS: fsys, Jobs: jobs})
+		if result.errorCount() != 0 {
+			t.Fatalf("%d jobs: expected no errors, actual %v", jobs, result.Errors)
+		}
+		for name, count := range fsys.reads {
+			if count != 1 {
+				t.Errorf("%d jobs: %s was read %d times", jobs, name, count)
+			}
+		}
+
+		got := make(map[string][]byte)
+		fsys.Walk("site", func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				got[path], err = fsys.ReadFile(path)
+			}
+			return err
+		})
+		if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d jobs: expected the same files as with 1 job", jobs)
+		}
+	}
+}
+
+func TestRunCache(t *testing.T) {
+	cleanTestDirectory(t)
+	modTime := time.Now().Add(-time.Hour)
+	for name, content := range map[string]string{
+		"index.html":   `<link rel="stylesheet" href="css/site.css"><script src="app.js"></script>`,
+		"app.js":       `console.log("cached")`,
+		"css/site.css": `body{background:url("../img/bg.png")}`,
+		"img/bg.png":   "png",
+	} {
+		path := filepath.Join("./test/site", filepath.FromSlash(name))
+		if err := (OSFS{}).WriteFile(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheFile := filepath.Join("./test/site", CacheFileName)
+	readTree := func(dir string) map[string]string {
+		tree := make(map[string]string)
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				b, _ := ioutil.ReadFile(path)
+				rel, _ := filepath.Rel(dir, path)
+				tree[filepath.ToSlash(rel)] = string(b)
+			}
+			return err
+		})
+		return tree
+	}
+
+	cold := run(t, Options{Dir: "./test/site", OutDir: "./test/cold", Cache: cacheFile, SRI: "sha256"})
+	if cold.errorCount() != 0 {
+		t.Fatalf("expected no errors, actual %v", cold.Errors)
+	}
+	fsys := &countingFS{FS: OSFS{}, reads: make(map[string]int)}
+	warm := run(t, Options{Dir: "./test/site", OutDir: "./test/warm", Cache: cacheFile, SRI: "sha256", FS: fsys})
+	if warm.errorCount() != 0 {
+		t.Fatalf("expected no errors, actual %v", warm.Errors)
+	}
+	for _, asset := range []string{"test/site/app.js", "test/site/img/bg.png"} {
+		if fsys.reads[asset] != 1 { // copied to the output once, never read to hash
+			t.Errorf("expected %s to be read once, actual %d reads", asset, fsys.reads[asset])
+		}
+	}
+	if !reflect.DeepEqual(readTree("./test/warm"), readTree("./test/cold")) {
+		t.Errorf("output with a warm cache differs from a cold run:\n%v\n%v", readTree("./test/warm"), readTree("./test/cold"))
+	}
+
+	err := ioutil.WriteFile("./test/site/app.js", []byte(`console.log("edited")`), 0644) // same size
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime = modTime.Add(time.Minute)
+	if err := os.Chtimes("./test/site/app.js", modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	run(t, Options{Dir: "./test/site", OutDir: "./test/edited", Cache: cacheFile})
+	run(t, Options{Dir: "./test/site", OutDir: "./test/uncached"})
+	if edited, uncached := readTree("./test/edited"), readTree("./test/uncached"); !reflect.DeepEqual(edited, uncached) {
+		t.Errorf("an edited file was not hashed again:\n%v\n%v", edited, uncached)
+	}
+
+	inPlace := run(t, Options{Dir: "./test/site", Cache: cacheFile})
+	fsys = &countingFS{FS: OSFS{}, reads: make(map[string]int)}
+	again := run(t, Options{Dir: "./test/site", Cache: cacheFile, FS: fsys})
+	for _, rename := range inPlace.Renames {
+		if !isCSSFile(rename.To) && fsys.reads[filepath.Clean(rename.To)] != 0 {
+			t.Errorf("expected %s, renamed by the last run, to be hashed from the cache", rename.To)
+		}
+	}
+	if len(again.Renames) != len(inPlace.Renames) || again.errorCount() != 0 {
+		t.Errorf("expected the same renames again, actual %v, errors %v", again.Renames, again.Errors)
+	}
+}
+
+// FS counting the reads of each file.
+type countingFS struct {
+	FS
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (c *countingFS) ReadFile(name string) ([]byte, error) {
+	c.mu.Lock()
+	c.reads[filepath.Clean(name)]++
+	c.mu.Unlock()
+	return c.FS.ReadFile(name)
+}
+
+func TestPrune(t *testing.T) {
+	cleanTestDirectory(t)
+	files := []struct {
+		path    string
+		content string
+	}{ // oldest first
+		{"index.html", `<script src="app-cc3.js"></script><link rel="stylesheet" href="css/style-cc10.css">`},
+		{"app.js", `console.log("source")`},
+		{"app-cc1.js", ""},
+		{"app-cc2.js", ""},
+		{"app-cc3.js", ""},
+		{"css/style-cc9.css", `body{background:url(bg-cc4.png)}`},
+		{"css/bg-cc4.png", ""},
+		{"css/style-cc10.css", `body{background:url(bg-cc5.png)}`},
+		{"css/bg-cc5.png", ""},
+		{"lib-cc6.js", ""},
+		{"lib-cc7.js", ""},
+		{"lib-cc8.js", ""},
+		{"manifest.json", `{"lib.js": {"path": "lib-cc7.js", "hash": "cc7", "size": 0, "html": []}}`},
+		{"vendor/old-cc1.js", ""},
+		{"vendor/old-cc2.js", ""},
+	}
+	modTime := time.Now().Add(-time.Hour)
+	for _, file := range files {
+		path := filepath.Join("./test", filepath.FromSlash(file.path))
+		err := OSFS{}.WriteFile(path, []byte(file.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime = modTime.Add(time.Minute)
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := Options{Dir: "./test", Manifest: "./test/manifest.json", Exclude: []string{"vendor"}, Keep: 1, DryRun: true}
+	removed := []string{"test/app-cc1.js", "test/app-cc2.js", "test/css/bg-cc4.png", "test/css/style-cc9.css", "test/lib-cc6.js"}
+
+	result, err := Prune(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Removed, " ") != strings.Join(removed, " ") {
+		t.Errorf("dry run: expected to remove %v, actual %v", removed, result.Removed)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join("./test", file.path)); err != nil {
+			t.Errorf("dry run removed %s", file.path)
+		}
+	}
+
+	opts.DryRun = false
+	result, err = Prune(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.errorCount() != 0 {
+		t.Errorf("expected no errors, actual %v", result.Errors)
+	}
+	if strings.Join(result.Removed, " ") != strings.Join(removed, " ") {
+		t.Errorf("expected to remove %v, actual %v", removed, result.Removed)
+	}
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join("./test", file.path))
+		if wasRemoved := strings.Contains(" "+strings.Join(removed, " ")+" ", " test/"+file.path+" "); wasRemoved != os.IsNotExist(err) {
+			t.Errorf("%s: expected removed %v, actual stat error %v", file.path, wasRemoved, err)
+		}
+	}
+
+	opts.Dir = ""
+	opts.OutDir = "./test"
+	opts.Manifest = ""
+	result, err = Prune(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"test/lib-cc7.js"}; strings.Join(result.Removed, " ") != strings.Join(expected, " ") {
+		t.Errorf("pruning -out without a manifest: expected to remove %v, actual %v", expected, result.Removed)
+	}
+}
+
+func run(t *testing.T, opts Options) *Result {
+	t.Helper()
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
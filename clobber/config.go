@@ -0,0 +1,174 @@
+package clobber
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigFileNames are the config files FindConfig looks for, in order.
+var ConfigFileNames = []string{"cache-clobber.json", "cache-clobber.toml"}
+
+// Config of a project, checked into source control as json or toml.
+// Keys are the names of the command line flags, relative paths are relative to the config file.
+type Config struct {
+	Roots        []string `json:"roots,omitempty"` // directories to run in, each on its own, the config's directory when empty
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	Hash         string   `json:"hash,omitempty"`
+	HashEncoding string   `json:"hash-encoding,omitempty"`
+	HashLength   int      `json:"hash-length,omitempty"`
+	LegacyHashes []string `json:"legacy-hash,omitempty"`
+	NameTemplate string   `json:"name-template,omitempty"`
+	Keep         *int     `json:"keep,omitempty"` // used by prune, 0 when nil
+	Jobs         int      `json:"jobs,omitempty"`
+	SRI          string   `json:"sri,omitempty"`
+	CrossOrigin  string   `json:"crossorigin,omitempty"`
+	Out          string   `json:"out,omitempty"`
+	Manifest     string   `json:"manifest,omitempty"`
+	Journal      string   `json:"journal,omitempty"`
+	Cache        string   `json:"cache,omitempty"`
+	NoCache      bool     `json:"no-cache,omitempty"`
+	Rules        []Rule   `json:"rules,omitempty"` // DefaultRules when empty
+}
+
+// FindConfig returns the first of ConfigFileNames in dir, or "" when there is none.
+func FindConfig(dir string) (string, error) {
+	for _, name := range ConfigFileNames {
+		path := filepath.Join(dir, name)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// LoadConfig reads the json or toml config at path, by its extension, and validates it.
+// Relative paths in it are made relative to the working directory.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(path, b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range c.Roots {
+		c.Roots[i] = resolvePath(dir, c.Roots[i])
+	}
+	if len(c.Roots) == 0 {
+		c.Roots = []string{dir}
+	}
+	c.Out = resolvePath(dir, c.Out)
+	c.Manifest = resolvePath(dir, c.Manifest)
+	c.Journal = resolvePath(dir, c.Journal)
+	c.Cache = resolvePath(dir, c.Cache)
+
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func parseConfig(path string, b []byte) (*Config, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".toml":
+		table, err := parseTOML(string(b))
+		if err != nil {
+			return nil, err
+		}
+		b, err = json.Marshal(table) // decoded like json, so both formats have the same keys and checks
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q, want .json or .toml", ext)
+	}
+
+	c := &Config{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err := d.Decode(c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Returns path joined to dir, unless it is empty or absolute.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Validate returns an error for the first invalid setting of c.
+func (c *Config) Validate() error {
+	if len(c.Roots) > 1 {
+		for _, setting := range [][2]string{{"out", c.Out}, {"manifest", c.Manifest}, {"journal", c.Journal}, {"cache", c.Cache}} {
+			if setting[1] != "" {
+				return fmt.Errorf("%s can only be set with a single root, there are %d", setting[0], len(c.Roots))
+			}
+		}
+	}
+	for _, opts := range c.Options() {
+		err := opts.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options returns the options of a Run in each root.
+func (c *Config) Options() []Options {
+	roots := c.Roots
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	all := make([]Options, 0, len(roots))
+	for _, root := range roots {
+		opts := Options{
+			Dir:          root,
+			Include:      c.Include,
+			Exclude:      c.Exclude,
+			Hash:         c.Hash,
+			HashEncoding: c.HashEncoding,
+			HashLength:   c.HashLength,
+			LegacyHashes: c.LegacyHashes,
+			NameTemplate: c.NameTemplate,
+			Jobs:         c.Jobs,
+			SRI:          c.SRI,
+			CrossOrigin:  c.CrossOrigin,
+			OutDir:       c.Out,
+			Manifest:     c.Manifest,
+			Journal:      c.Journal,
+			Cache:        c.Cache,
+		}
+		if len(c.Rules) > 0 {
+			opts.Rules = c.Rules
+		}
+		if c.NoCache {
+			opts.Cache = ""
+		}
+		if c.Keep != nil {
+			opts.Keep = *c.Keep
+		}
+		all = append(all, opts)
+	}
+	return all
+}
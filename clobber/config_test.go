@@ -0,0 +1,109 @@
+package clobber
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cache-clobber.json": `{
+  "roots": ["public"],
+  "exclude": ["node_modules"],
+  "hash": "sha256",
+  "hash-encoding": "hex",
+  "hash-length": 12,
+  "manifest": "build/manifest.json",
+  "rules": [
+    {"tag": "script", "attr": "src", "extensions": [".js"]},
+    {"tag": "img", "attr": "srcset", "extensions": [".png", ".webp"]}
+  ]
+}`,
+		"cache-clobber.toml": `# same config as the json
+roots = ["public"]
+exclude = [
+  "node_modules", # trailing comma and comments are fine
+]
+hash = "sha256"
+hash-encoding = 'hex'
+hash-length = 12
+manifest = "build/manifest.json"
+
+[[rules]]
+tag = "script"
+attr = "src"
+extensions = [".js"]
+
+[[rules]]
+tag = "img"
+attr = "srcset"
+extensions = [".png", ".webp"]
+`,
+	}
+	expected := &Config{
+		Roots:        []string{filepath.Join(dir, "public")},
+		Exclude:      []string{"node_modules"},
+		Hash:         "sha256",
+		HashEncoding: "hex",
+		HashLength:   12,
+		Manifest:     filepath.Join(dir, "build", "manifest.json"),
+		Rules: []Rule{
+			{Tag: "script", Attr: "src", Extensions: []string{".js"}},
+			{Tag: "img", Attr: "srcset", Extensions: []string{".png", ".webp"}},
+		},
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadConfig(path)
+		if err != nil {
+			t.Errorf("LoadConfig(%s): errored %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("LoadConfig(%s): expected %+v, actual %+v", name, expected, c)
+		}
+	}
+
+	found, err := FindConfig(dir)
+	if err != nil || found != filepath.Join(dir, "cache-clobber.json") {
+		t.Errorf("FindConfig: expected the json config, actual %q, %v", found, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string // in the error
+	}{
+		{"unknown.json", `{"hashes": "md5"}`, `unknown field "hashes"`},
+		{"syntax.json", `{"hash": }`, "invalid character"},
+		{"hash.json", `{"hash": "sha512"}`, `unknown hash algorithm "sha512"`},
+		{"rule.json", `{"rules": [{"tag": "img"}]}`, "rule 1 needs both a tag and an attribute"},
+		{"roots.json", `{"roots": ["a", "b"], "out": "dist"}`, "out can only be set with a single root"},
+		{"glob.toml", `exclude = ["[a-"]`, "syntax error in pattern"},
+		{"type.toml", `hash-length = "12"`, "cannot unmarshal string"},
+		{"line.toml", "hash = \"md5\"\nsri = sha256\n", "line 2: unsupported value"},
+		{"config.yaml", `hash: md5`, `unknown config format ".yaml"`},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) || !strings.HasPrefix(err.Error(), path) {
+			t.Errorf("LoadConfig(%s): expected an error about %q, actual %v", tt.name, tt.expected, err)
+		}
+	}
+}
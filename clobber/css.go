@@ -0,0 +1,158 @@
+package clobber
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Adds every url() and @import reference in the css file at cssFilePath to graph.
+func addCSSJobs(result *Result, graph *assetGraph, cssFilePath, fileContent string) {
+	dir, _ := filepath.Split(cssFilePath)
+	for _, ref := range cssReferences(fileContent) {
+		ti := tagInfo{
+			tagType:  "css",
+			wholeTag: ref.wholeRef,
+			startTag: ref.start,
+		}
+		attr := tagAttr{
+			value:    fileContent[ref.valStart:ref.valEnd],
+			valStart: ref.valStart,
+			valEnd:   ref.valEnd,
+		}
+		addJob(result, graph, dir, attr.value, cssFilePath, ti, attr)
+	}
+}
+
+func isCSSFile(filePath string) bool {
+	return strings.EqualFold(filepath.Ext(filePath), ".css")
+}
+
+type cssRef struct {
+	wholeRef string // the url(...) or @import string
+	start    int
+	valStart int // offset of the referenced path
+	valEnd   int // before any query or fragment
+}
+
+// Returns the local file references of url() and @import in css fileContent.
+// Comments and strings outside of them are skipped, as are urls with a scheme, absolute paths and fragments.
+func cssReferences(fileContent string) []cssRef {
+	refs := []cssRef{}
+	s := fileContent
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return refs
+			}
+			i += 2 + end + 2
+		case s[i] == '"' || s[i] == '\'':
+			i = cssStringEnd(s, i)
+		case hasPrefixFold(s[i:], "url(") && (i == 0 || !isCSSNameChar(s[i-1])):
+			ref, end := cssURL(s, i)
+			if isLocalCSSRef(s[ref.valStart:ref.valEnd]) {
+				refs = append(refs, ref)
+			}
+			i = end
+		case hasPrefixFold(s[i:], "@import"):
+			j := i + len("@import")
+			for j < len(s) && isTagSpace(s[j]) {
+				j++
+			}
+			if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+				valStart, valEnd, end := cssString(s, j)
+				ref := cssRef{
+					wholeRef: s[j:end],
+					start:    j,
+					valStart: valStart,
+					valEnd:   valStart + cssPathLength(s[valStart:valEnd]),
+				}
+				if isLocalCSSRef(s[ref.valStart:ref.valEnd]) {
+					refs = append(refs, ref)
+				}
+				j = end
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return refs
+}
+
+// Returns the url() starting at i and the offset just past it.
+func cssURL(s string, i int) (cssRef, int) {
+	j := i + len("url(")
+	for j < len(s) && isTagSpace(s[j]) {
+		j++
+	}
+	ref := cssRef{start: i}
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		ref.valStart, ref.valEnd, j = cssString(s, j)
+	} else {
+		ref.valStart = j
+		for j < len(s) && s[j] != ')' && !isTagSpace(s[j]) {
+			j++
+		}
+		ref.valEnd = j
+	}
+	for j < len(s) && s[j] != ')' {
+		j++
+	}
+	if j < len(s) {
+		j++ // past ')'
+	}
+	ref.wholeRef = s[i:j]
+	ref.valEnd = ref.valStart + cssPathLength(s[ref.valStart:ref.valEnd])
+	return ref, j
+}
+
+// Returns the offsets of the contents of the css string starting with the quote at i, and the offset just past it.
+func cssString(s string, i int) (int, int, int) {
+	end := cssStringEnd(s, i)
+	if end-1 > i && s[end-1] == s[i] {
+		return i + 1, end - 1, end
+	}
+	return i + 1, end, end // unterminated
+}
+
+// Returns the offset just past the css string starting with the quote at i.
+func cssStringEnd(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote, '\n':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// Returns the length of value without its query or fragment.
+func cssPathLength(value string) int {
+	if i := strings.IndexAny(value, "?#"); i != -1 {
+		return i
+	}
+	return len(value)
+}
+
+// Reports whether a css reference is a relative path to a local file.
+func isLocalCSSRef(path string) bool {
+	if path == "" || path[0] == '/' || strings.HasPrefix(path, "#") {
+		return false
+	}
+	slash := strings.IndexByte(path, '/')
+	colon := strings.IndexByte(path, ':')
+	return colon == -1 || (slash != -1 && slash < colon) // a colon before any slash is a scheme, like data: or https:
+}
+
+func isCSSNameChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '-' || c == '_'
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
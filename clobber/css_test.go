@@ -0,0 +1,39 @@
+package clobber
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSSReferences(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{in: `a{background:url(a.png)}`, expected: []string{"a.png"}},
+		{in: `a{background:URL( 'b c.png' )}`, expected: []string{"b c.png"}},
+		{in: `a{background:url("a.svg#icon")}`, expected: []string{"a.svg"}},
+		{in: `@import "a.css";@import url(b.css) screen;@IMPORT 'c.css';`, expected: []string{"a.css", "b.css", "c.css"}},
+		{in: `/* url(a.png) @import "b.css"; */`, expected: []string{}},
+		{in: `a{content:"url(a.png)"}`, expected: []string{}},
+		{in: `a{background:url(data:image/png;base64,AA) url(https://x.com/a.png) url(//x.com/a.png) url(/a.png) url(#a)}`, expected: []string{}},
+		{in: `a{mask:myurl(a.png)}`, expected: []string{}},
+		{in: `a{background:url(../img/a.png?v=2)}`, expected: []string{"../img/a.png"}},
+		{in: `a{background:url("`, expected: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			refs := cssReferences(tt.in)
+			actual := []string{}
+			for _, ref := range refs {
+				actual = append(actual, tt.in[ref.valStart:ref.valEnd])
+				if tt.in[ref.start:ref.start+len(ref.wholeRef)] != ref.wholeRef {
+					t.Errorf("cssReferences(%s): offset %d does not match %s", tt.in, ref.start, ref.wholeRef)
+				}
+			}
+			if strings.Join(actual, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("cssReferences(%s) = %v, want %v", tt.in, actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package clobber
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3 // lines of context around each hunk
+
+// Returns a unified diff of before, the contents of fromFile, and after, the contents of toFile.
+// Edits only ever replace attribute values, so lines are compared one to one.
+func unifiedDiff(fromFile, toFile, before, after string) string {
+	if before == after {
+		return ""
+	}
+	a := diffLines(before)
+	b := diffLines(after)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromFile, toFile)
+	if len(a) != len(b) {
+		writeHunk(&sb, a, b, 0, len(a))
+		return sb.String()
+	}
+
+	for i := 0; i < len(a); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i + 1
+		for j := end; j < len(a) && j < end+2*diffContext; j++ { // merge changes closer than two contexts apart
+			if a[j] != b[j] {
+				end = j + 1
+			}
+		}
+		end += diffContext
+		if end > len(a) {
+			end = len(a)
+		}
+		writeHunk(&sb, a, b, start, end)
+		i = end - 1
+	}
+	return sb.String()
+}
+
+func diffLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Writes the lines a[start:end] and b[start:end] as one hunk.
+// When a and b differ in length the hunk covers both in full.
+func writeHunk(sb *strings.Builder, a, b []string, start, end int) {
+	if len(a) != len(b) {
+		fmt.Fprintf(sb, "@@ -1,%d +1,%d @@\n", len(a), len(b))
+		for _, line := range a {
+			writeDiffLine(sb, "-", line)
+		}
+		for _, line := range b {
+			writeDiffLine(sb, "+", line)
+		}
+		return
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", start+1, end-start, start+1, end-start)
+	for i := start; i < end; {
+		if a[i] == b[i] {
+			writeDiffLine(sb, " ", a[i])
+			i++
+			continue
+		}
+		j := i
+		for j < end && a[j] != b[j] {
+			j++
+		}
+		for _, line := range a[i:j] {
+			writeDiffLine(sb, "-", line)
+		}
+		for _, line := range b[i:j] {
+			writeDiffLine(sb, "+", line)
+		}
+		i = j
+	}
+}
+
+func writeDiffLine(sb *strings.Builder, prefix, line string) {
+	sb.WriteString(prefix)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n\\ No newline at end of file\n")
+	}
+}
@@ -0,0 +1,37 @@
+package clobber
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+	}{
+		{name: "equal", before: "a\nb\n", after: "a\nb\n", expected: ""},
+		{name: "one line", before: "a\nb\nc\n", after: "a\nB\nc\n", expected: "--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{
+			name:     "context",
+			before:   "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			after:    "1\nX\n3\n4\n5\n6\n7\n8\n9\n10\n11\nY\n",
+			expected: "--- f\n+++ f\n@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+Y\n",
+		},
+		{
+			name:     "merged hunks",
+			before:   "1\n2\n3\n4\n5\n6\n",
+			after:    "X\n2\n3\n4\n5\nY\n",
+			expected: "--- f\n+++ f\n@@ -1,6 +1,6 @@\n-1\n+X\n 2\n 3\n 4\n 5\n-6\n+Y\n",
+		},
+		{name: "no newline", before: "a", after: "b", expected: "--- f\n+++ f\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f", "f", tt.before, tt.after); got != tt.expected {
+				t.Errorf("unifiedDiff() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
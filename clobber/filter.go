@@ -0,0 +1,143 @@
+package clobber
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file in the scanned directory listing exclude patterns, one per line.
+// Blank lines and lines starting with # are skipped.
+const IgnoreFileName = ".cacheclobberignore"
+
+// Selects which html files are scanned and which assets are hashed, by glob patterns.
+// Patterns match slash separated paths relative to the scanned directory.
+// * and ? do not match a slash, ** matches any number of directories,
+// and a pattern without a slash matches a name at any depth, like in .gitignore.
+// A pattern matching a directory matches everything under it.
+type pathFilter struct {
+	baseDir string
+	include []string // a file must match one, when there are any
+	exclude []string // a file or directory must match none
+}
+
+// Returns the filter of include and exclude patterns, plus the exclude patterns in baseDir's IgnoreFileName.
+func newPathFilter(fsys FS, baseDir string, include, exclude []string) (*pathFilter, error) {
+	f := &pathFilter{baseDir: baseDir}
+	ignored, err := readIgnoreFile(fsys, filepath.Join(baseDir, IgnoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range include {
+		p, err := normalizePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, pattern := range append(append([]string{}, exclude...), ignored...) {
+		p, err := normalizePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+func readIgnoreFile(fsys FS, ignoreFile string) ([]string, error) {
+	b, err := fsys.ReadFile(ignoreFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	patterns := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// Returns pattern anchored to the scanned directory, or under ** when it has no slash.
+func normalizePattern(pattern string) (string, error) {
+	p := strings.TrimSuffix(filepath.ToSlash(pattern), "/") // a trailing slash only marks a directory
+	if p == "" {
+		return "", fmt.Errorf("empty glob pattern %q", pattern)
+	}
+	if !strings.Contains(p, "/") {
+		p = "**/" + p
+	}
+	p = strings.TrimPrefix(p, "/")
+	for _, elem := range strings.Split(p, "/") {
+		if _, err := path.Match(elem, ""); err != nil {
+			return "", fmt.Errorf("glob pattern %q: %w", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// Reports whether the file at filePath is scanned or hashed.
+func (f *pathFilter) selected(filePath string) bool {
+	rel, err := relSlashPath(f.baseDir, filePath)
+	if err != nil {
+		return false
+	}
+	outside := rel == ".." || strings.HasPrefix(rel, "../") // no include pattern can name it
+	if len(f.include) > 0 && (outside || !matchAny(f.include, rel)) {
+		return false
+	}
+	return !matchAny(f.exclude, rel)
+}
+
+// Reports whether everything under the directory at dirPath is excluded.
+func (f *pathFilter) excludedDir(dirPath string) bool {
+	rel, err := relSlashPath(f.baseDir, dirPath)
+	if err != nil || rel == "." {
+		return false
+	}
+	return matchAny(f.exclude, rel)
+}
+
+// Reports whether any pattern matches rel or a directory holding it.
+func matchAny(patterns []string, rel string) bool {
+	elems := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		patternElems := strings.Split(pattern, "/")
+		for i := 1; i <= len(elems); i++ {
+			if matchElems(patternElems, elems[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches path elements against pattern elements, where a ** element matches any number of path elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], elems[0]); !matched {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
@@ -0,0 +1,45 @@
+package clobber
+
+import "testing"
+
+func TestPathFilter(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"site/" + IgnoreFileName: []byte("# generated\n\nbuild/\n*.min.js\n"),
+	})
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		path     string
+		selected bool
+	}{
+		{name: "no patterns", path: "site/index.html", selected: true},
+		{name: "name at any depth", exclude: []string{"node_modules"}, path: "site/a/node_modules/x/x.js", selected: false},
+		{name: "anchored", exclude: []string{"/vendor"}, path: "site/a/vendor/x.js", selected: true},
+		{name: "anchored match", exclude: []string{"/vendor"}, path: "site/vendor/x.js", selected: false},
+		{name: "double star", exclude: []string{"assets/**/*.css"}, path: "site/assets/a/b/c.css", selected: false},
+		{name: "double star zero dirs", exclude: []string{"assets/**/*.css"}, path: "site/assets/c.css", selected: false},
+		{name: "star stops at slash", exclude: []string{"assets/*.css"}, path: "site/assets/a/c.css", selected: true},
+		{name: "ignore file directory", path: "site/build/index.html", selected: false},
+		{name: "ignore file name", path: "site/js/app.min.js", selected: false},
+		{name: "include", include: []string{"public"}, path: "site/public/app.js", selected: true},
+		{name: "not included", include: []string{"public"}, path: "site/app.js", selected: false},
+		{name: "exclude wins", include: []string{"public/**"}, exclude: []string{"*.map"}, path: "site/public/app.js.map", selected: false},
+		{name: "outside dir", include: []string{"**"}, path: "other/app.js", selected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newPathFilter(fsys, "site", tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if selected := f.selected(tt.path); selected != tt.selected {
+				t.Errorf("selected(%q) = %v, want %v", tt.path, selected, tt.selected)
+			}
+		})
+	}
+
+	if _, err := newPathFilter(fsys, "site", []string{"[a-"}, nil); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
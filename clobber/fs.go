@@ -0,0 +1,309 @@
+package clobber
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FS is the filesystem a Run or Restore reads and writes through.
+// Names are paths as given in Options, joined with filepath.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, b []byte) error // creates any missing parent directories
+	Rename(from, to string) error          // creates any missing parent directories of to
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Walk(root string, fn filepath.WalkFunc) error // same order and SkipDir handling as filepath.Walk
+}
+
+// OSFS is the FS of the operating system, used when Options.FS is nil.
+type OSFS struct{}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (OSFS) WriteFile(name string, b []byte) error {
+	err := os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+func (OSFS) Rename(from, to string) error {
+	err := os.MkdirAll(filepath.Dir(to), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+// MemFS is an in-memory FS, safe for concurrent use.
+// Directories only exist through the files in them.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]memFile // [clean slash separated name]file
+}
+
+type memFile struct {
+	b       []byte
+	modTime time.Time
+}
+
+// NewMemFS returns a MemFS holding files, keyed by name.
+func NewMemFS(files map[string][]byte) *MemFS {
+	m := &MemFS{files: make(map[string]memFile)}
+	for name, b := range files {
+		m.WriteFile(name, b)
+	}
+	return m
+}
+
+// LoadFS returns a MemFS holding a copy of every file in fsys, such as an embed.FS or a zip.Reader.
+func LoadFS(fsys fs.FS) (*MemFS, error) {
+	m := NewMemFS(nil)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return m.WriteFile(name, b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns the key of name in MemFS.files.
+func memName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, exists := m.files[memName(name)]
+	if !exists {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	b := make([]byte, len(f.b))
+	copy(b, f.b)
+	return b, nil
+}
+
+func (m *MemFS) WriteFile(name string, b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memName(name)
+	if m.isDir(key) {
+		return &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	f := memFile{b: make([]byte, len(b)), modTime: time.Now()}
+	copy(f.b, b)
+	m.files[key] = f
+	return nil
+}
+
+// Rename moves a file, replacing any file at to.
+func (m *MemFS) Rename(from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, exists := m.files[memName(from)]
+	if !exists {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	}
+	if m.isDir(memName(to)) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EISDIR}
+	}
+	delete(m.files, memName(from))
+	m.files[memName(to)] = f
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.files[memName(name)]; !exists {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(m.files, memName(name))
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, exists := m.stat(memName(name))
+	if !exists {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return info, nil
+}
+
+// Walk calls fn for root and everything under it like filepath.Walk, on a snapshot of m taken when it starts.
+func (m *MemFS) Walk(root string, fn filepath.WalkFunc) error {
+	m.mu.RLock()
+	rootInfo, exists := m.stat(memName(root))
+	rels := []string{} // slash separated paths of every file and directory under root, relative to root
+	infos := make(map[string]os.FileInfo)
+	if exists && rootInfo.IsDir() {
+		for key, f := range m.files {
+			rel, under := memRel(memName(root), key)
+			if !under {
+				continue
+			}
+			infos[rel] = memFileInfo{name: path.Base(rel), size: int64(len(f.b)), modTime: f.modTime}
+			rels = append(rels, rel)
+			for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+				if _, seen := infos[dir]; seen {
+					break
+				}
+				infos[dir] = memFileInfo{name: path.Base(dir), dir: true}
+				rels = append(rels, dir)
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	if !exists {
+		err := fn(root, nil, &os.PathError{Op: "lstat", Path: root, Err: os.ErrNotExist})
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	err := fn(root, rootInfo, nil)
+	if err != nil || !rootInfo.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	sort.Slice(rels, func(i, j int) bool { // by path element, as filepath.Walk visits each directory in name order
+		return lessPathElements(rels[i], rels[j])
+	})
+	skipped := []string{} // directories whose remaining contents are skipped
+	for _, rel := range rels {
+		if hasDirPrefix(rel, skipped) {
+			continue
+		}
+		info := infos[rel]
+		err := fn(filepath.Join(root, filepath.FromSlash(rel)), info, nil)
+		if err == filepath.SkipDir {
+			dir := rel
+			if !info.IsDir() {
+				dir = path.Dir(rel) // skips the rest of the directory holding the file
+				if dir == "." {
+					return nil
+				}
+			}
+			skipped = append(skipped, dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the info of the file or directory key, called with m.mu held.
+func (m *MemFS) stat(key string) (os.FileInfo, bool) {
+	if f, exists := m.files[key]; exists {
+		return memFileInfo{name: path.Base(key), size: int64(len(f.b)), modTime: f.modTime}, true
+	}
+	if m.isDir(key) {
+		return memFileInfo{name: path.Base(key), dir: true}, true
+	}
+	return nil, false
+}
+
+// Reports whether any file is under the directory key, called with m.mu held.
+func (m *MemFS) isDir(key string) bool {
+	for name := range m.files {
+		if _, under := memRel(key, name); under {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns key relative to the directory root, and whether key is inside root at all.
+func memRel(root, key string) (string, bool) {
+	if root == "." {
+		if path.IsAbs(key) || key == ".." || strings.HasPrefix(key, "../") {
+			return "", false
+		}
+		return key, true
+	}
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if !strings.HasPrefix(key, prefix) || key == prefix {
+		return "", false
+	}
+	return key[len(prefix):], true
+}
+
+func lessPathElements(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func hasDirPrefix(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
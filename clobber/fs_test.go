@@ -0,0 +1,87 @@
+package clobber
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemFSWalk(t *testing.T) {
+	files := map[string][]byte{
+		"root/a-b.html":     []byte("a-b"),
+		"root/a/x.js":       []byte("x"),
+		"root/a/y/z.css":    []byte("z"),
+		"root/b.js":         []byte("b"),
+		"root/skip/s.js":    []byte("s"),
+		"root/skip/t/u.css": []byte("u"),
+		"other/o.js":        []byte("o"),
+	}
+	dir := t.TempDir()
+	for name, b := range files {
+		err := OSFS{}.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func(fsys FS, root string) []string {
+		visited := []string{}
+		err := fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			visited = append(visited, filepath.ToSlash(rel))
+			if info.IsDir() && info.Name() == "skip" {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return visited
+	}
+
+	expected := walk(OSFS{}, filepath.Join(dir, "root"))
+	actual := walk(NewMemFS(files), "./root")
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected MemFS to walk like filepath.Walk\nexpected %v\nactual   %v", expected, actual)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{"dir/a.js": []byte("a")})
+
+	err := fsys.Rename("./dir/a.js", "dir/b.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.ReadFile("dir/a.js"); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error reading a renamed file, actual %v", err)
+	}
+	b, err := fsys.ReadFile("dir/../dir/b.js")
+	if err != nil || string(b) != "a" {
+		t.Errorf("expected to read the renamed file, actual %q, %v", b, err)
+	}
+
+	info, err := fsys.Stat("dir")
+	if err != nil || !info.IsDir() {
+		t.Errorf("expected dir to be a directory, actual %v, %v", info, err)
+	}
+	if err := fsys.WriteFile("dir", []byte("x")); err == nil {
+		t.Error("expected writing over a directory to fail")
+	}
+
+	err = fsys.Remove("dir/b.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("dir"); !os.IsNotExist(err) {
+		t.Errorf("expected dir to be gone with its last file, actual %v", err)
+	}
+}
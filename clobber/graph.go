@@ -0,0 +1,343 @@
+package clobber
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reads the html files at htmlFilePaths, workers at once, and adds their references to graph in the order of htmlFilePaths.
+// Files that can not be read are reported in result. Only fails when ctx is done.
+func (g *assetGraph) addHTMLFiles(ctx context.Context, result *Result, htmlFilePaths []string, workers int) error {
+	type scan struct {
+		content    string
+		refs       []htmlRef
+		unselected []htmlRef
+		err        error
+	}
+	scans := make([]scan, len(htmlFilePaths))
+	err := forEach(ctx, workers, len(htmlFilePaths), func(i int) {
+		b, err := g.fs.ReadFile(htmlFilePaths[i])
+		if err != nil {
+			scans[i].err = err
+			return
+		}
+		scans[i].content = string(b)
+		for _, ti := range tagsFromHTML(scans[i].content) {
+			scans[i].refs = append(scans[i].refs, selectedRefs(g.rules, ti)...)
+			scans[i].unselected = append(scans[i].unselected, unselectedRefs(g.rules, ti)...)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, htmlFilePath := range htmlFilePaths {
+		if scans[i].err != nil {
+			result.addError(htmlFilePath, scans[i].err)
+			continue
+		}
+		addEditJobs(result, g, htmlFilePath, scans[i].content, scans[i].refs, scans[i].unselected)
+	}
+	return nil
+}
+
+// Adds refs, the references selected by the graph's rules in the html file at htmlFilePath, to graph.
+// The files of unselected, the references the rules do not select, are recorded as referenced unhashed.
+func addEditJobs(editsErrors *Result, graph *assetGraph, htmlFilePath, fileContent string, refs, unselected []htmlRef) {
+	graph.roots = append(graph.roots, htmlFilePath)
+	graph.isRoot[htmlFilePath] = true
+	graph.scanned[htmlFilePath] = fileContent
+	dir, _ := filepath.Split(htmlFilePath)
+	for _, ref := range refs {
+		addJob(editsErrors, graph, dir, ref.attr.value, htmlFilePath, ref.ti, ref.attr)
+	}
+	for _, ref := range unselected {
+		graph.unhashedRefs[filepath.Clean(dir+ref.attr.value)] = true
+	}
+}
+
+func httpPrefixed(s string) bool {
+	prefix := "http"
+	if len(s) < len(prefix) {
+		return false
+	}
+	if s[:4] == prefix {
+		return true
+	}
+	return false
+}
+
+// Adds the reference to srcHref in htmlFilePath to graph.
+// A referenced css file is read and its own references added too.
+func addJob(result *Result, graph *assetGraph, dir string, srcHref string, htmlFilePath string, ti tagInfo, attr tagAttr) {
+	ref := assetRef{
+		from:    htmlFilePath,
+		dir:     dir,
+		srcHref: srcHref,
+		ti:      ti,
+		attr:    attr,
+		path:    filepath.Clean(dir + srcHref),
+	}
+	if !graph.filter.selected(ref.path) {
+		return
+	}
+	graph.refs[htmlFilePath] = append(graph.refs[htmlFilePath], ref)
+	if !isCSSFile(ref.path) {
+		return
+	}
+	if _, read := graph.scanned[ref.path]; read {
+		return
+	}
+
+	b, err := graph.fs.ReadFile(ref.path)
+	if err != nil {
+		return // reported when the css is hashed
+	}
+	graph.scanned[ref.path] = string(b)
+	addCSSJobs(result, graph, ref.path, string(b))
+}
+
+// Directed graph of files and the assets they reference, html -> css/js -> images/fonts.
+type assetGraph struct {
+	fs           FS
+	filter       *pathFilter           // assets it does not select are left out
+	rules        []Rule                // html references to hash
+	refs         map[string][]assetRef // [html or css file]references, in the order they appear
+	roots        []string              // html files, in the order they were added
+	isRoot       map[string]bool
+	scanned      map[string]string // [html or css file]contents, read once while adding references
+	unhashedRefs map[string]bool   // [path]referenced by html through a tag the rules do not select
+}
+
+type assetRef struct {
+	from    string // html or css file holding the reference
+	dir     string // directory of from
+	srcHref string // referenced path, relative to dir
+	ti      tagInfo
+	attr    tagAttr
+	path    string // clean path of the referenced asset
+}
+
+func newAssetGraph(fsys FS, filter *pathFilter, rules []Rule) *assetGraph {
+	return &assetGraph{
+		fs:           fsys,
+		filter:       filter,
+		rules:        rules,
+		refs:         make(map[string][]assetRef),
+		isRoot:       make(map[string]bool),
+		scanned:      make(map[string]string),
+		unhashedRefs: make(map[string]bool),
+	}
+}
+
+// Returns every file in graph with each asset ordered before the files referencing it.
+// Files on a reference cycle are left out and reported in cycles, each cycle as the files on it.
+func (g *assetGraph) sorted() (order []string, cycles [][]string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	onCycle := make(map[string]bool)
+	stack := []string{}
+
+	var visit func(path string)
+	visit = func(path string) {
+		state[path] = visiting
+		stack = append(stack, path)
+		for _, ref := range g.refs[path] {
+			switch state[ref.path] {
+			case unvisited:
+				visit(ref.path)
+			case visiting:
+				i := len(stack) - 1
+				for stack[i] != ref.path {
+					i--
+				}
+				cycle := append([]string{}, stack[i:]...)
+				for _, p := range cycle {
+					onCycle[p] = true
+				}
+				cycles = append(cycles, append(cycle, ref.path))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[path] = visited
+		if !onCycle[path] {
+			order = append(order, path)
+		}
+	}
+	for _, root := range g.roots {
+		if state[root] == unvisited {
+			visit(root)
+		}
+	}
+	return order, cycles
+}
+
+// Hashes every asset in graph in dependency order and returns the jobs rewriting every reference to them.
+// A css file is hashed after its references are rewritten, so a changed image changes the hash of the css using it.
+// Assets cache has an entry for are not read, and the hash of every other asset referencing nothing is stored in it.
+// In place, assets html also references through tags the rules do not select are left unhashed, so those references still resolve.
+// Only fails when ctx is done.
+func (g *assetGraph) jobs(ctx context.Context, result *Result, opts Options, h hasher, names nameTemplate, cache *hashCache) ([]*job, error) {
+	order, cycles := g.sorted()
+	for _, cycle := range cycles {
+		result.addError(cycle[0], fmt.Errorf("reference cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	jobs := []*job{}
+	hashed := make(map[string]*hashedAsset)
+	failed := make(map[string]error)
+	unhashed := func(path string) bool {
+		return opts.OutDir == "" && g.unhashedRefs[path]
+	}
+
+	leaves := []string{} // assets referencing nothing, hashed up front and at once
+	for _, path := range order {
+		if _, scanned := g.scanned[path]; !scanned && !unhashed(path) {
+			leaves = append(leaves, path)
+		}
+	}
+	leafAssets := make([]*hashedAsset, len(leaves))
+	leafErrors := make([]error, len(leaves))
+	leafInfos := make([]os.FileInfo, len(leaves)) // of assets to add to cache
+	err := forEach(ctx, opts.workers(), len(leaves), func(i int) {
+		var info os.FileInfo
+		if cache != nil {
+			var err error
+			info, err = g.fs.Stat(leaves[i])
+			if err != nil {
+				leafErrors[i] = err
+				return
+			}
+			if entry, cached := cache.lookup(leaves[i], info); cached {
+				leafAssets[i] = &hashedAsset{
+					name:      names.hashedName(opts.Dir, leaves[i], entry.Hash, h),
+					ccHash:    entry.Hash,
+					integrity: entry.Integrity,
+				}
+				leafInfos[i] = info
+				return
+			}
+		}
+		b, err := g.fs.ReadFile(leaves[i])
+		if err != nil {
+			leafErrors[i] = err
+			return
+		}
+		leafAssets[i] = newHashedAsset(leaves[i], b, opts, h, names)
+		leafInfos[i] = info
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range leaves {
+		if leafErrors[i] != nil {
+			failed[path] = leafErrors[i]
+			continue
+		}
+		hashed[path] = leafAssets[i]
+		if leafInfos[i] != nil {
+			cache.store(path, leafInfos[i], leafAssets[i])
+		}
+	}
+
+	for _, path := range order {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fileJobs := []*job{}
+		for _, ref := range g.refs[path] {
+			if err, exists := failed[ref.path]; exists {
+				result.addError(path, err)
+				continue
+			}
+			asset, exists := hashed[ref.path]
+			if !exists {
+				continue // on a cycle, already reported, or left unhashed
+			}
+			fileJobs = append(fileJobs, newJob(ref, asset, opts))
+		}
+		jobs = append(jobs, fileJobs...)
+
+		content, isCSS := g.scanned[path]
+		if g.isRoot[path] || !isCSS || unhashed(path) {
+			continue // html files are not renamed, other assets are already hashed
+		}
+		if moved := names.movedDir(opts.Dir, path, h); moved != "." {
+			for _, job := range fileJobs {
+				job.rebase = moved // references are relative to where the css ends up
+			}
+		}
+		hashed[path] = newHashedAsset(path, []byte(applyJobs(content, fileJobs)), opts, h, names)
+	}
+	return jobs, nil
+}
+
+// Hashes the asset at path with contents b.
+func newHashedAsset(path string, b []byte, opts Options, h hasher, names nameTemplate) *hashedAsset {
+	asset := &hashedAsset{}
+	asset.ccHash = "cc" + h.sum(b)
+	asset.name = names.hashedName(opts.Dir, path, asset.ccHash, h)
+	if opts.SRI != "" {
+		asset.integrity = integrity(b, opts.SRI)
+	}
+	return asset
+}
+
+// Returns the job rewriting ref to the hashed asset.
+func newJob(ref assetRef, asset *hashedAsset, opts Options) *job {
+	integrity := ""
+	if ref.ti.tagType == "script" || ref.ti.tagType == "link" && isCSSFile(ref.path) {
+		integrity = asset.integrity
+	}
+
+	_, originalName := filepath.Split(ref.dir + ref.srcHref)
+	tagLocalPath, _ := filepath.Split(ref.srcHref)
+	return &job{
+		fileNameWantToRename: originalName,
+		filePathWantToRename: ref.dir + ref.srcHref,
+		renameTo:             asset.name,
+		hash:                 asset.ccHash,
+		tagPath:              tagLocalPath,
+		htmlFile:             ref.from,
+		wholeTag:             ref.ti.wholeTag,
+		startTag:             ref.ti.startTag,
+		valStart:             ref.attr.valStart,
+		valEnd:               ref.attr.valEnd,
+		attrs:                ref.ti.attrs,
+		selfClosing:          ref.ti.selfClosing,
+		integrity:            integrity,
+		assetIntegrity:       asset.integrity,
+		crossOrigin:          opts.CrossOrigin,
+	}
+}
+
+var sriAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// Returns the subresource integrity value of b, e.g. "sha384-...".
+// algorithm must be one of sriAlgorithms.
+func integrity(b []byte, algorithm string) string {
+	h := sriAlgorithms[algorithm]()
+	h.Write(b) // never returns an error
+	return algorithm + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+type hashedAsset struct {
+	name      string // hashed file name, relative to the directory of the file and slash separated
+	ccHash    string
+	integrity string // subresource integrity value, only set when requested
+}
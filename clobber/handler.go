@@ -0,0 +1,116 @@
+package clobber
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestHandler serves the assets of a manifest, for backends serving their static directory themselves.
+// Request paths are relative to the scanned directory, use http.StripPrefix to serve them under a prefix.
+//
+// The hashed path of an entry is served as FileServer serves hashed files, cached for a year as immutable.
+// The original path of an entry is redirected to the hashed path when Redirect is set,
+// else the hashed file is served at it, revalidated on each use as the contents behind the name change.
+// Any other generation of an asset in the manifest is stale and answered with 404 Not Found.
+// Every other request is passed to Next, or answered with 404 Not Found when it is nil.
+// Assets of a manifest from HashManifest were never renamed, their original file is served at the hashed path.
+type ManifestHandler struct {
+	Redirect bool         // redirect original paths to their hashed path, instead of serving the hashed file at them
+	Next     http.Handler // handles paths the manifest does not name, and methods other than GET and HEAD
+	fs       FS
+	root     string
+	names    nameTemplate
+	h        hasher
+	manifest Manifest
+	hashed   map[string]string // [hashed path]original path
+}
+
+// NewManifestHandler returns a handler serving the assets of m, from ReadManifest or HashManifest,
+// from opts.OutDir, or opts.Dir when it is empty. Stale hashes are recognised by the hash settings and NameTemplate of opts.
+// Only Dir, OutDir, FS and the naming options of opts are used.
+func NewManifestHandler(m Manifest, opts Options) (*ManifestHandler, error) {
+	h, names, root, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if opts.OutDir != "" {
+		root = opts.OutDir
+	}
+
+	hashed := make(map[string]string)
+	for original, entry := range m {
+		hashed[entry.Path] = original
+	}
+	return &ManifestHandler{fs: opts.fs(), root: root, names: names, h: h, manifest: m, hashed: hashed}, nil
+}
+
+func (s *ManifestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.next(w, r)
+		return
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if original, exists := s.hashed[rel]; exists {
+		s.serveEntry(w, r, original, true)
+		return
+	}
+	if entry, exists := s.manifest[rel]; exists {
+		if !s.Redirect {
+			s.serveEntry(w, r, rel, false)
+			return
+		}
+		// relative, so the redirect holds under any prefix the handler is mounted at
+		location, err := filepath.Rel(path.Dir(rel), entry.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		location = filepath.ToSlash(location)
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", location)
+		w.Header().Set("Cache-Control", RevalidateCacheControl)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	if original, ccHash := s.names.original(rel, s.h); ccHash != "" {
+		if _, exists := s.manifest[original]; exists {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	s.next(w, r)
+}
+
+// Serves the hashed file of the asset at original, cached as immutable when it is requested by its hashed path.
+func (s *ManifestHandler) serveEntry(w http.ResponseWriter, r *http.Request, original string, immutable bool) {
+	entry := s.manifest[original]
+	name := filepath.Join(s.root, filepath.FromSlash(entry.Path))
+	info, err := s.fs.Stat(name)
+	if os.IsNotExist(err) {
+		name = filepath.Join(s.root, filepath.FromSlash(original)) // hashed in memory only, by HashManifest
+		info, err = s.fs.Stat(name)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := s.fs.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveFile(w, r, info, b, entry.Hash, immutable)
+}
+
+func (s *ManifestHandler) next(w http.ResponseWriter, r *http.Request) {
+	if s.Next == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.Next.ServeHTTP(w, r)
+}
@@ -0,0 +1,70 @@
+package clobber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManifestHandler(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"dist/manifest.json":   []byte(`{"js/app.js": {"path": "js/app-cc123.js", "hash": "cc123", "size": 21, "html": ["index.html"]}}`),
+		"dist/js/app-cc123.js": []byte(`console.log("hashed")`),
+		"dist/js/app-cc456.js": []byte(`console.log("stale")`),
+		"dist/js/other-cc7.js": []byte(`console.log("other")`),
+	})
+	m, err := ReadManifest(fsys, "dist/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewManifestHandler(m, Options{Dir: "src", OutDir: "dist", FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	tests := []struct {
+		redirect     bool
+		target       string
+		status       int
+		cacheControl string
+		location     string
+	}{
+		{target: "/js/app-cc123.js", status: 200, cacheControl: ImmutableCacheControl},
+		{target: "/js/app.js", status: 200, cacheControl: RevalidateCacheControl},
+		{redirect: true, target: "/js/app.js?v=1", status: 302, cacheControl: RevalidateCacheControl, location: "app-cc123.js?v=1"},
+		{redirect: true, target: "/js/app-cc123.js", status: 200, cacheControl: ImmutableCacheControl},
+		{target: "/js/app-cc456.js", status: 404},
+		{target: "/js/other-cc7.js", status: http.StatusTeapot},
+		{target: "/index.html", status: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		handler.Redirect = tt.redirect
+		w := get(tt.target)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, actual %d", tt.target, tt.status, w.Code)
+			continue
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); tt.cacheControl != "" && cacheControl != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, actual %q", tt.target, tt.cacheControl, cacheControl)
+		}
+		if location := w.Header().Get("Location"); location != tt.location {
+			t.Errorf("%s: expected Location %q, actual %q", tt.target, tt.location, location)
+		}
+		if tt.status == 200 && (w.Header().Get("ETag") != `"cc123"` || w.Body.String() != `console.log("hashed")`) {
+			t.Errorf("%s: expected the hashed file, actual ETag %q, body %q", tt.target, w.Header().Get("ETag"), w.Body.String())
+		}
+	}
+
+	_, err = ReadManifest(fsys, "dist/missing.json")
+	if err == nil {
+		t.Error("expected an error for a missing manifest")
+	}
+}
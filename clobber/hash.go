@@ -0,0 +1,218 @@
+package clobber
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"math/big"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Splits fileName into the name before its cc hash, the cc hash and the extension.
+// ccHash is empty when fileName is not hashed.
+// The hash must follow the last dash before the extension and have the exact alphabet and length of a format from hashFormats.
+func splitCCHash(fileName string, h hasher) (name, ccHash, ext string) {
+	ext = filepath.Ext(fileName)
+	stem := fileName[:len(fileName)-len(ext)]
+
+	dashes := []int{}
+	for i := 0; i+3 <= len(stem); i++ {
+		if stem[i:i+3] == "-cc" {
+			dashes = append(dashes, i)
+		}
+	}
+	for _, format := range hashFormats(h) {
+		for _, i := range dashes {
+			if possibleHash := stem[i+1:]; format.matches(possibleHash[len("cc"):]) {
+				return stem[:i], possibleHash, ext
+			}
+		}
+	}
+	return stem, "", ext
+}
+
+// Alphabet and length of the hashes of a hasher.
+type hashFormat struct {
+	alphabet  string
+	minLength int
+	maxLength int
+}
+
+// Reports whether s, without its cc prefix, is a hash of format f.
+func (f hashFormat) matches(s string) bool {
+	if len(s) < f.minLength || len(s) > f.maxLength {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(f.alphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the regexp matching a cc hash of format f.
+func (f hashFormat) pattern() string {
+	class := strings.Replace(regexp.QuoteMeta(f.alphabet), "-", `\-`, -1)
+	return fmt.Sprintf("cc[%s]{%d,%d}", class, f.minLength, f.maxLength)
+}
+
+// Untruncated hash settings whose hashes are recognised whatever the current ones, as no ordinary word matches them.
+// Short ones like crc32 in base32, which matches "compile", are only recognised when given as legacy hashes.
+var unambiguousHashes = []string{
+	"crc32/decimal", "fnv64/decimal", "fnv64/hex",
+	"md5/hex", "md5/base64url", "sha1/hex", "sha1/base64url", "sha256/hex", "sha256/base64url",
+}
+
+// Returns the format of the hashes h produces, then the untruncated formats of unambiguousHashes and of h's legacy settings,
+// longest first, so files hashed by earlier runs are recognised as well.
+func hashFormats(h hasher) []hashFormat {
+	others := []hashFormat{}
+	seen := map[hashFormat]bool{h.format(): true}
+	for _, legacy := range append(append([]string{}, unambiguousHashes...), h.legacy...) {
+		algorithm, encoding := splitLegacyHash(legacy)
+		format := hasher{algorithm: algorithm, encoding: encoding}.format()
+		if !seen[format] {
+			seen[format] = true
+			others = append(others, format)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if others[i].maxLength != others[j].maxLength {
+			return others[i].maxLength > others[j].maxLength
+		}
+		return others[i].alphabet < others[j].alphabet
+	})
+	return append([]hashFormat{h.format()}, others...)
+}
+
+// Hashes file contents for their cc hash.
+// The zero value is the original crc32 printed in decimal.
+type hasher struct {
+	algorithm string   // sha256, sha1, md5, crc32 or fnv64, crc32 when empty
+	encoding  string   // decimal, hex, base32 or base64url, decimal when empty
+	length    int      // characters of the encoded hash kept, all when 0
+	legacy    []string // algorithm/encoding of earlier runs, whose untruncated hashes are recognised too
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"fnv64":  func() hash.Hash { return fnv.New64a() },
+}
+
+// Encodings of hash sums, with their alphabet and the range of lengths they encode size bytes in.
+var hashEncodings = map[string]hashEncoding{
+	"decimal": {
+		encode:   func(b []byte) string { return new(big.Int).SetBytes(b).String() },
+		alphabet: "0123456789",
+		length: func(size int) (int, int) {
+			max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(size*8)), big.NewInt(1))
+			return 1, len(max.String()) // leading zeros are dropped
+		},
+	},
+	"hex": {
+		encode:   hex.EncodeToString,
+		alphabet: "0123456789abcdef",
+		length:   func(size int) (int, int) { return size * 2, size * 2 },
+	},
+	"base32": {
+		encode: func(b []byte) string {
+			return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		},
+		alphabet: "abcdefghijklmnopqrstuvwxyz234567",
+		length:   func(size int) (int, int) { return (size*8 + 4) / 5, (size*8 + 4) / 5 },
+	},
+	"base64url": {
+		encode:   base64.RawURLEncoding.EncodeToString,
+		alphabet: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+		length:   func(size int) (int, int) { return (size*8 + 5) / 6, (size*8 + 5) / 6 },
+	},
+}
+
+type hashEncoding struct {
+	encode   func([]byte) string
+	alphabet string
+	length   func(size int) (min, max int)
+}
+
+func newHasher(algorithm, encoding string, length int, legacy []string) (hasher, error) {
+	h := hasher{algorithm: algorithm, encoding: encoding, length: length, legacy: legacy}
+	if _, exists := hashAlgorithms[h.algorithmOrDefault()]; !exists {
+		return h, fmt.Errorf("unknown hash algorithm %q, want one of sha256, sha1, md5, crc32 or fnv64", algorithm)
+	}
+	if _, exists := hashEncodings[h.encodingOrDefault()]; !exists {
+		return h, fmt.Errorf("unknown hash encoding %q, want one of decimal, hex, base32 or base64url", encoding)
+	}
+	if length < 0 {
+		return h, fmt.Errorf("hash length %d is negative", length)
+	}
+	for _, settings := range legacy {
+		algorithm, encoding := splitLegacyHash(settings)
+		_, algorithmExists := hashAlgorithms[algorithm]
+		_, encodingExists := hashEncodings[encoding]
+		if !algorithmExists || !encodingExists {
+			return h, fmt.Errorf("unknown legacy hash %q, want an algorithm/encoding like crc32/hex", settings)
+		}
+	}
+	return h, nil
+}
+
+// Splits legacy hash settings written as algorithm/encoding.
+func splitLegacyHash(settings string) (algorithm, encoding string) {
+	i := strings.Index(settings, "/")
+	if i == -1 {
+		return settings, ""
+	}
+	return settings[:i], settings[i+1:]
+}
+
+func (h hasher) algorithmOrDefault() string {
+	if h.algorithm == "" {
+		return "crc32"
+	}
+	return h.algorithm
+}
+
+func (h hasher) encodingOrDefault() string {
+	if h.encoding == "" {
+		return "decimal"
+	}
+	return h.encoding
+}
+
+// Returns the encoded hash of b, without the cc prefix.
+func (h hasher) sum(b []byte) string {
+	hh := hashAlgorithms[h.algorithmOrDefault()]()
+	hh.Write(b) // never returns an error
+	encoded := hashEncodings[h.encodingOrDefault()].encode(hh.Sum(nil))
+	if h.length > 0 && h.length < len(encoded) {
+		return encoded[:h.length]
+	}
+	return encoded
+}
+
+// Returns the format of the hashes sum returns.
+func (h hasher) format() hashFormat {
+	encoding := hashEncodings[h.encodingOrDefault()]
+	min, max := encoding.length(hashAlgorithms[h.algorithmOrDefault()]().Size())
+	if h.length > 0 && h.length < max {
+		max = h.length
+		if min > max {
+			min = max
+		}
+	}
+	return hashFormat{alphabet: encoding.alphabet, minLength: min, maxLength: max}
+}
@@ -0,0 +1,146 @@
+package clobber
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestHasher(t *testing.T) {
+	content := []byte(`console.log("cool and good")`)
+	tests := []struct {
+		name   string
+		h      hasher
+		length int
+	}{
+		{name: "default", h: hasher{}, length: 0},
+		{name: "crc32 hex", h: hasher{algorithm: "crc32", encoding: "hex"}, length: 8},
+		{name: "sha256 hex", h: hasher{algorithm: "sha256", encoding: "hex"}, length: 64},
+		{name: "sha1 base32", h: hasher{algorithm: "sha1", encoding: "base32"}, length: 32},
+		{name: "md5 base64url", h: hasher{algorithm: "md5", encoding: "base64url"}, length: 22},
+		{name: "fnv64 hex", h: hasher{algorithm: "fnv64", encoding: "hex"}, length: 16},
+		{name: "sha256 truncated", h: hasher{algorithm: "sha256", encoding: "base64url", length: 10}, length: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := tt.h.sum(content)
+			if f := tt.h.format(); tt.length != 0 && (f.minLength != tt.length || f.maxLength != tt.length) {
+				t.Errorf("format() lengths = %d to %d, want %d", f.minLength, f.maxLength, tt.length)
+			}
+			if tt.length != 0 && len(sum) != tt.length {
+				t.Errorf("sum() = %s, want length %d", sum, tt.length)
+			}
+			if !tt.h.format().matches(sum) {
+				t.Errorf("format().matches(%s) = false, want true", sum)
+			}
+		})
+	}
+
+	if sum := (hasher{}).sum(content); sum != fmt.Sprint(crc32.ChecksumIEEE(content)) {
+		t.Errorf("default sum() = %s, want the crc32 in decimal %d", sum, crc32.ChecksumIEEE(content))
+	}
+
+	_, err := newHasher("sha512", "hex", 0, nil)
+	if err == nil {
+		t.Error("newHasher(sha512): expected an unknown algorithm error")
+	}
+	_, err = newHasher("sha256", "base16", 0, nil)
+	if err == nil {
+		t.Error("newHasher(base16): expected an unknown encoding error")
+	}
+	_, err = newHasher("", "", 0, []string{"sha256"})
+	if err == nil {
+		t.Error("newHasher(legacy sha256): expected an error for a legacy hash without an encoding")
+	}
+}
+
+func TestSplitCCHash(t *testing.T) {
+	tests := []struct {
+		in     string
+		h      hasher
+		name   string
+		ccHash string
+		ext    string
+	}{
+		{in: "cool.js", name: "cool", ccHash: "", ext: ".js"},
+		{in: "already-hashed-cc123.js", name: "already-hashed", ccHash: "cc123", ext: ".js"},
+		{in: "weird-ccna-name.js", name: "weird-ccna-name", ccHash: "", ext: ".js"},
+		{in: "weird-ccna-name-already-hashed-cc123.js", name: "weird-ccna-name-already-hashed", ccHash: "cc123", ext: ".js"},
+		{in: "no-extension-cc123", name: "no-extension", ccHash: "cc123", ext: ""},
+		{in: "no-extension", name: "no-extension", ccHash: "", ext: ""},
+		{in: "app-ccab-ccdef.js", name: "app-ccab-ccdef", ccHash: "", ext: ".js"},
+		{in: "app-cc12-cc34.js", name: "app-cc12", ccHash: "cc34", ext: ".js"},
+		{in: "access.js", name: "access", ccHash: "", ext: ".js"},
+		{in: "cc123.js", name: "cc123", ccHash: "", ext: ".js"},
+		{in: "LICENSE", name: "LICENSE", ccHash: "", ext: ""},
+		{in: "notes-ccbeef.js", name: "notes-ccbeef", ccHash: "", ext: ".js"},
+		{in: "app-ccdeadbeef.js", name: "app-ccdeadbeef", ccHash: "", ext: ".js"},
+		{in: "app-ccdeadbeef.js", h: hasher{legacy: []string{"crc32/hex"}}, name: "app", ccHash: "ccdeadbeef", ext: ".js"},
+		{in: "x-ccompile.js", name: "x-ccompile", ccHash: "", ext: ".js"},
+		{in: "my-ccomputer.js", name: "my-ccomputer", ccHash: "", ext: ".js"},
+		{in: "x-ccompile.js", h: hasher{algorithm: "sha256", encoding: "hex"}, name: "x-ccompile", ccHash: "", ext: ".js"},
+		{in: "app-cc2530066345.js", h: hasher{algorithm: "sha256", encoding: "hex"}, name: "app", ccHash: "cc2530066345", ext: ".js"},
+		{in: "app-ccdeadbeef.js", h: hasher{algorithm: "sha256", encoding: "hex"}, name: "app-ccdeadbeef", ccHash: "", ext: ".js"},
+		{in: "app-cc" + strings.Repeat("0f", 32) + ".js", name: "app", ccHash: "cc" + strings.Repeat("0f", 32), ext: ".js"},
+		{in: "app-cc" + strings.Repeat("0f", 8) + ".js", name: "app", ccHash: "cc" + strings.Repeat("0f", 8), ext: ".js"},
+		{in: "app-ccAbCdEfGhIjKlMnOpQrSt_-.js", name: "app", ccHash: "ccAbCdEfGhIjKlMnOpQrSt_-", ext: ".js"},
+		{in: "app-ccAbCdEfGhIjK.js", name: "app-ccAbCdEfGhIjK", ccHash: "", ext: ".js"},
+		{in: "app-ccAbCdEfGhIjK.js", h: hasher{legacy: []string{"fnv64/base64url"}}, name: "app", ccHash: "ccAbCdEfGhIjK", ext: ".js"},
+		{in: "app-ccab-ccdef.js", h: hasher{algorithm: "sha256", encoding: "base64url", length: 8}, name: "app", ccHash: "ccab-ccdef", ext: ".js"},
+		{in: "app-ccab12cd34.js", h: hasher{algorithm: "sha256", encoding: "hex", length: 4}, name: "app-ccab12cd34", ccHash: "", ext: ".js"},
+		{in: "app-ccab12cd34.js", h: hasher{algorithm: "sha256", encoding: "hex", length: 4, legacy: []string{"crc32/hex"}}, name: "app", ccHash: "ccab12cd34", ext: ".js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			name, ccHash, ext := splitCCHash(tt.in, tt.h)
+			if name != tt.name || ccHash != tt.ccHash || ext != tt.ext {
+				t.Errorf("splitCCHash(%s) = %s, %s, %s, want %s, %s, %s", tt.in, name, ccHash, ext, tt.name, tt.ccHash, tt.ext)
+			}
+		})
+	}
+
+	hashers := []hasher{{}, {algorithm: "sha256", encoding: "base64url"}, {algorithm: "md5", encoding: "base32", length: 7}, {algorithm: "fnv64", encoding: "decimal", length: 3}}
+	for _, h := range hashers {
+		for _, fileName := range []string{"app.js", "weird-ccna-name.js", "my-cc-1.min.css", "LICENSE", ".htaccess"} {
+			for i := 0; i < 50; i++ {
+				content := []byte(fmt.Sprint(fileName, i))
+				once := nameTemplate{}.hashedName("", fileName, "cc"+h.sum(content), h)
+				twice := nameTemplate{}.hashedName("", once, "cc"+h.sum(content), h)
+				if once != twice {
+					t.Errorf("%+v: hashing %s again renamed %s to %s", h, fileName, once, twice)
+				}
+			}
+		}
+	}
+}
+
+func TestHashFormat(t *testing.T) {
+	tests := []struct {
+		h    hasher
+		s    string
+		want bool
+	}{
+		{h: hasher{}, s: "234", want: true},
+		{h: hasher{}, s: "4294967295", want: true},
+		{h: hasher{}, s: "42949672950", want: false},
+		{h: hasher{}, s: "kwrwrwrwr", want: false},
+		{h: hasher{}, s: "", want: false},
+		{h: hasher{algorithm: "crc32", encoding: "hex"}, s: "deadbeef", want: true},
+		{h: hasher{algorithm: "crc32", encoding: "hex"}, s: "deadbee", want: false},
+		{h: hasher{algorithm: "crc32", encoding: "hex"}, s: "DEADBEEF", want: false},
+		{h: hasher{algorithm: "sha1", encoding: "base32"}, s: "abcdefghijklmnopqrstuvwxyz234567", want: true},
+		{h: hasher{algorithm: "sha1", encoding: "base32"}, s: "abcdefghijklmnopqrstuvwxyz234561", want: false},
+		{h: hasher{algorithm: "md5", encoding: "base64url"}, s: "ab-_CD0123456789abcdef", want: true},
+		{h: hasher{algorithm: "md5", encoding: "base64url"}, s: "ab-_CD0123456789abcde=", want: false},
+		{h: hasher{algorithm: "sha256", encoding: "hex", length: 4}, s: "ab12", want: true},
+		{h: hasher{algorithm: "sha256", encoding: "hex", length: 4}, s: "ab123", want: false},
+		{h: hasher{algorithm: "sha256", encoding: "decimal", length: 6}, s: "12", want: true},
+		{h: hasher{algorithm: "sha256", encoding: "decimal", length: 6}, s: "1234567", want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.h.format().matches(tt.s); got != tt.want {
+			t.Errorf("%+v.format().matches(%q) = %v, want %v", tt.h, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package clobber
+
+import (
+	"strings"
+)
+
+type tagAttr struct {
+	name     string
+	value    string
+	nameEnd  int // offset one past the name in the scanned content
+	valStart int // offset of value in the scanned content, -1 when the attribute has no value
+	valEnd   int
+}
+
+type tagInfo struct {
+	tagType     string // lower cased
+	wholeTag    string
+	startTag    int
+	endTag      int // offset one past the closing '>'
+	attrs       map[string]tagAttr
+	selfClosing bool
+}
+
+// Elements whose contents are text up until their end tag, never markup.
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+}
+
+type tokenizer struct {
+	content string
+	pos     int
+	tags    []tagInfo
+}
+
+// Returns every start tag found in fileContent.
+// Comments, doctypes, CDATA blocks, end tags and the bodies of raw text elements are skipped.
+func tagsFromHTML(fileContent string) []tagInfo {
+	z := &tokenizer{
+		content: fileContent,
+		tags:    make([]tagInfo, 0),
+	}
+	for z.pos < len(z.content) {
+		i := strings.IndexByte(z.content[z.pos:], '<')
+		if i == -1 {
+			break
+		}
+		z.pos += i
+		z.readMarkup()
+	}
+	return z.tags
+}
+
+func (z *tokenizer) readMarkup() {
+	rest := z.content[z.pos:]
+	switch {
+	case strings.HasPrefix(rest, "<!--"):
+		z.skipPast("-->", 2) // from 2 so "<!-->" closes itself
+	case hasPrefixFold(rest, "<![CDATA["):
+		z.skipPast("]]>", 9)
+	case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+		z.skipPast(">", 2) // doctype or bogus comment
+	case strings.HasPrefix(rest, "</"):
+		if len(rest) > 2 && isASCIILetter(rest[2]) {
+			z.readTag(true)
+			return
+		}
+		z.skipPast(">", 2)
+	case len(rest) > 1 && isASCIILetter(rest[1]):
+		z.readTag(false)
+	default:
+		z.pos++ // a lone '<' is text
+	}
+}
+
+// Moves pos past the next occurrence of s, searching from pos+from.
+// Moves to the end of content if s never occurs.
+func (z *tokenizer) skipPast(s string, from int) {
+	if z.pos+from > len(z.content) {
+		z.pos = len(z.content)
+		return
+	}
+	i := strings.Index(z.content[z.pos+from:], s)
+	if i == -1 {
+		z.pos = len(z.content)
+		return
+	}
+	z.pos += from + i + len(s)
+}
+
+type tagState int
+
+const (
+	beforeAttrName tagState = iota
+	attrName
+	afterAttrName
+	beforeAttrValue
+	attrValueDoubleQuoted
+	attrValueSingleQuoted
+	attrValueUnquoted
+	afterAttrValueQuoted
+	selfClosingStartTag
+)
+
+// Reads the tag starting at pos, a start tag unless isEnd.
+// An unterminated tag at the end of content is dropped.
+func (z *tokenizer) readTag(isEnd bool) {
+	s := z.content
+	start := z.pos
+	i := start + 1
+	if isEnd {
+		i++
+	}
+	nameStart := i
+	for i < len(s) && !isTagSpace(s[i]) && s[i] != '/' && s[i] != '>' {
+		i++
+	}
+	tag := tagInfo{
+		tagType:  strings.ToLower(s[nameStart:i]),
+		startTag: start,
+		attrs:    make(map[string]tagAttr),
+	}
+
+	state := beforeAttrName
+	var attr tagAttr
+	nameFrom := 0
+	addAttr := func() {
+		if _, exists := tag.attrs[attr.name]; !exists { // first occurrence wins
+			tag.attrs[attr.name] = attr
+		}
+	}
+
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch state {
+		case beforeAttrName:
+			switch {
+			case isTagSpace(c):
+			case c == '/':
+				state = selfClosingStartTag
+			case c == '>':
+				z.emit(tag, isEnd, i)
+				return
+			default:
+				nameFrom = i
+				state = attrName
+			}
+		case attrName:
+			switch {
+			case isTagSpace(c), c == '/', c == '>', c == '=':
+				attr = tagAttr{name: strings.ToLower(s[nameFrom:i]), nameEnd: i, valStart: -1, valEnd: -1}
+				state = afterAttrName
+				i-- // reconsume in afterAttrName
+			}
+		case afterAttrName:
+			switch {
+			case isTagSpace(c):
+			case c == '=':
+				state = beforeAttrValue
+			default:
+				addAttr()
+				state = beforeAttrName
+				i--
+			}
+		case beforeAttrValue:
+			switch {
+			case isTagSpace(c):
+			case c == '"':
+				attr.valStart = i + 1
+				state = attrValueDoubleQuoted
+			case c == '\'':
+				attr.valStart = i + 1
+				state = attrValueSingleQuoted
+			case c == '>':
+				attr.valStart, attr.valEnd = i, i
+				addAttr()
+				z.emit(tag, isEnd, i)
+				return
+			default:
+				attr.valStart = i
+				state = attrValueUnquoted
+			}
+		case attrValueDoubleQuoted, attrValueSingleQuoted:
+			if (state == attrValueDoubleQuoted && c == '"') || (state == attrValueSingleQuoted && c == '\'') {
+				attr.valEnd = i
+				attr.value = s[attr.valStart:i]
+				addAttr()
+				state = afterAttrValueQuoted
+			}
+		case attrValueUnquoted:
+			if isTagSpace(c) || c == '>' {
+				attr.valEnd = i
+				attr.value = s[attr.valStart:i]
+				addAttr()
+				state = beforeAttrName
+				i--
+			}
+		case afterAttrValueQuoted:
+			state = beforeAttrName
+			i--
+		case selfClosingStartTag:
+			if c == '>' {
+				tag.selfClosing = true
+				z.emit(tag, isEnd, i)
+				return
+			}
+			state = beforeAttrName
+			i--
+		}
+	}
+	z.pos = len(s)
+}
+
+// Records tag ending at the '>' at offset end, then skips the body of raw text elements.
+func (z *tokenizer) emit(tag tagInfo, isEnd bool, end int) {
+	z.pos = end + 1
+	if isEnd {
+		return
+	}
+	tag.endTag = z.pos
+	tag.wholeTag = z.content[tag.startTag:tag.endTag]
+	z.tags = append(z.tags, tag)
+
+	if rawTextElements[tag.tagType] {
+		z.skipRawText(tag.tagType)
+	}
+}
+
+// Moves pos to the end tag closing the raw text element name.
+func (z *tokenizer) skipRawText(name string) {
+	s := z.content
+	for i := z.pos; i+2+len(name) <= len(s); i++ {
+		if s[i] != '<' || s[i+1] != '/' || !strings.EqualFold(s[i+2:i+2+len(name)], name) {
+			continue
+		}
+		after := i + 2 + len(name)
+		if after == len(s) || isTagSpace(s[after]) || s[after] == '/' || s[after] == '>' {
+			z.pos = i
+			return
+		}
+	}
+	z.pos = len(s)
+}
+
+func isTagSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
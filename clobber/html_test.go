@@ -0,0 +1,90 @@
+package clobber
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagsFromHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		types []string          // expected tagTypes in order
+		attrs map[string]string // expected attributes of the last tag
+	}{
+		{name: "basic", in: `<script src="a.js"></script>`, types: []string{"script"}, attrs: map[string]string{"src": "a.js"}},
+		{name: "gt in attribute", in: `<link title="a>b" href="a.css">`, types: []string{"link"}, attrs: map[string]string{"title": "a>b", "href": "a.css"}},
+		{name: "single quotes", in: `<link href='a.css' rel='x'>`, types: []string{"link"}, attrs: map[string]string{"href": "a.css", "rel": "x"}},
+		{name: "unquoted", in: `<script src=a.js defer></script>`, types: []string{"script"}, attrs: map[string]string{"src": "a.js", "defer": ""}},
+		{name: "case insensitive", in: `<SCRIPT SRC="A.js"></SCRIPT>`, types: []string{"script"}, attrs: map[string]string{"src": "A.js"}},
+		{name: "self closing", in: `<link href="a.css"/><br/>`, types: []string{"link", "br"}, attrs: map[string]string{}},
+		{name: "comment", in: `<!-- <script src="x.js"> --><p>`, types: []string{"p"}, attrs: map[string]string{}},
+		{name: "empty comment", in: `<!--><p id=1>`, types: []string{"p"}, attrs: map[string]string{"id": "1"}},
+		{name: "doctype", in: `<!DOCTYPE html><html lang="en">`, types: []string{"html"}, attrs: map[string]string{"lang": "en"}},
+		{name: "cdata", in: `<![CDATA[ <link href="x.css"> ]]><p>`, types: []string{"p"}, attrs: map[string]string{}},
+		{name: "script body", in: `<script>if (a<b) { x = '<link href="x.css">' }</script><p>`, types: []string{"script", "p"}, attrs: map[string]string{}},
+		{name: "style body", in: `<style>a>b{}</StYlE ><p>`, types: []string{"style", "p"}, attrs: map[string]string{}},
+		{name: "end tag ignored", in: `</div class="x>"><p>`, types: []string{"p"}, attrs: map[string]string{}},
+		{name: "lone lt", in: `1 < 2 <p>`, types: []string{"p"}, attrs: map[string]string{}},
+		{name: "valueless attribute", in: `<script integrity src="a.js">`, types: []string{"script"}, attrs: map[string]string{"integrity": "", "src": "a.js"}},
+		{name: "duplicate attribute", in: `<script src="a.js" src="b.js">`, types: []string{"script"}, attrs: map[string]string{"src": "a.js"}},
+		{name: "unterminated", in: `<p><script src="a.js"`, types: []string{"p"}, attrs: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := tagsFromHTML(tt.in)
+			if len(tags) != len(tt.types) {
+				t.Fatalf("tagsFromHTML(%s): expected %d tags, actual %d", tt.in, len(tt.types), len(tags))
+			}
+			for i, ti := range tags {
+				if ti.tagType != tt.types[i] {
+					t.Errorf("tagsFromHTML(%s): expected tag %s, actual %s", tt.in, tt.types[i], ti.tagType)
+				}
+				if tt.in[ti.startTag:ti.endTag] != ti.wholeTag {
+					t.Errorf("tagsFromHTML(%s): offsets %d:%d do not match %s", tt.in, ti.startTag, ti.endTag, ti.wholeTag)
+				}
+				for _, a := range ti.attrs {
+					if a.nameEnd < len(a.name) || strings.ToLower(tt.in[a.nameEnd-len(a.name):a.nameEnd]) != a.name {
+						t.Errorf("tagsFromHTML(%s): name offset of %s does not match", tt.in, a.name)
+					}
+					if a.valStart != -1 && tt.in[a.valStart:a.valEnd] != a.value {
+						t.Errorf("tagsFromHTML(%s): value offsets of %s do not match %s", tt.in, a.name, a.value)
+					}
+				}
+			}
+			last := tags[len(tags)-1]
+			for name, value := range tt.attrs {
+				if last.attrs[name].value != value {
+					t.Errorf("tagsFromHTML(%s): expected %s=%q, actual %q", tt.in, name, value, last.attrs[name].value)
+				}
+			}
+		})
+	}
+}
+
+func TestHttpPrefixed(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{name: "", args: args{"https://code.jquery.com/jquery-3.5.1.min.js"}, want: true},
+		{name: "", args: args{"http://lodash.com"}, want: true},
+		{name: "", args: args{"http"}, want: true},
+		{name: "", args: args{"ht"}, want: false},
+		{name: "", args: args{"h"}, want: false},
+		{name: "", args: args{""}, want: false},
+		{name: "", args: args{"yay.js"}, want: false},
+		{name: "", args: args{"./test/deep/down/file.js"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := httpPrefixed(tt.args.s); got != tt.want {
+				t.Errorf("httpPrefixed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
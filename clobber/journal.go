@@ -0,0 +1,260 @@
+package clobber
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// JournalFileName is the conventional name of the journal, kept in the scanned directory.
+const JournalFileName = ".cache-clobber-journal.json"
+
+// Record of every run that renamed files in place. Paths are slash separated and relative to the scanned directory.
+type journal struct {
+	Runs []journalRun `json:"runs"` // oldest first
+}
+
+type journalRun struct {
+	Renames []journalRename `json:"renames"`
+	HTML    []journalHTML   `json:"html"`
+}
+
+type journalRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type journalHTML struct {
+	Path       string          `json:"path"`
+	BeforeHash string          `json:"beforeHash"` // sha256 of the html before the run
+	AfterHash  string          `json:"afterHash"`  // sha256 of the html the run wrote
+	Edits      []journalRename `json:"edits"`      // attribute values replaced by the run
+}
+
+func readJournal(fsys FS, journalFile string) (*journal, error) {
+	j := &journal{}
+	b, err := fsys.ReadFile(journalFile)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, j)
+	if err != nil {
+		return nil, fmt.Errorf("reading journal %s: %w", journalFile, err)
+	}
+	return j, nil
+}
+
+// Writes j to journalFile, removing journalFile once no runs are left.
+func writeJournal(fsys FS, journalFile string, j *journal) error {
+	if len(j.Runs) == 0 {
+		err := fsys.Remove(journalFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(journalFile, append(b, '\n'))
+}
+
+// Appends the renames and html writes in result to journalFile as a new run.
+// A run that changed nothing is not recorded.
+func recordJournal(result *Result, fsys FS, baseDir, journalFile string) error {
+	run := journalRun{
+		Renames: []journalRename{},
+		HTML:    []journalHTML{},
+	}
+	for _, job := range result.Renames {
+		if job.From == job.To {
+			continue
+		}
+		from, err := relSlashPath(baseDir, job.From)
+		if err != nil {
+			return err
+		}
+		to, err := relSlashPath(baseDir, job.To)
+		if err != nil {
+			return err
+		}
+		run.Renames = append(run.Renames, journalRename{From: from, To: to})
+	}
+	for _, write := range result.writes {
+		if write.before == write.after {
+			continue
+		}
+		path, err := relSlashPath(baseDir, write.htmlFile)
+		if err != nil {
+			return err
+		}
+		html := journalHTML{
+			Path:       path,
+			BeforeHash: sha256Hex(write.before),
+			AfterHash:  sha256Hex(write.after),
+			Edits:      []journalRename{},
+		}
+		for _, job := range write.jobs {
+			html.Edits = append(html.Edits, journalRename{
+				From: job.tagPath + job.fileNameWantToRename,
+				To:   job.newValue(),
+			})
+		}
+		run.HTML = append(run.HTML, html)
+	}
+	if len(run.Renames) == 0 && len(run.HTML) == 0 {
+		return nil
+	}
+
+	j, err := readJournal(fsys, journalFile)
+	if err != nil {
+		return err
+	}
+	j.Runs = append(j.Runs, run)
+	return writeJournal(fsys, journalFile, j)
+}
+
+// Restore undoes every run in opts.Journal under opts.Dir, newest first.
+// Only Dir, Journal and FS of opts are used, Journal defaults to JournalFileName in Dir.
+// Each run's renames are reversed and the references it wrote into html are put back.
+// Runs are dropped from the journal as they are undone, so a failed or cancelled restore can be retried.
+// The returned error is for an unusable journal or a cancelled ctx, errors with single files are in Result.Errors.
+func Restore(ctx context.Context, opts Options) (*Result, error) {
+	fsys := opts.fs()
+	baseDir := opts.Dir
+	if baseDir == "" {
+		baseDir = "."
+	}
+	journalFile := opts.Journal
+	if journalFile == "" {
+		journalFile = filepath.Join(baseDir, JournalFileName)
+	}
+
+	result := newResult()
+	j, err := readJournal(fsys, journalFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(j.Runs) == 0 {
+		return nil, fmt.Errorf("nothing to restore, %s has no runs", journalFile)
+	}
+
+	for len(j.Runs) > 0 {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if !restoreRun(result, fsys, baseDir, &j.Runs[len(j.Runs)-1]) {
+			// keep what is left of the run, so a retry starts where this restore stopped
+			err := writeJournal(fsys, journalFile, j)
+			if err != nil {
+				result.addError("", err)
+			}
+			return result, nil
+		}
+		j.Runs = j.Runs[:len(j.Runs)-1]
+		err := writeJournal(fsys, journalFile, j)
+		if err != nil {
+			result.addError("", err)
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// Undoes run, returns false if it could not be undone completely.
+// Renames and html files are dropped from run as they are restored, leaving what is still to be undone.
+func restoreRun(result *Result, fsys FS, baseDir string, run *journalRun) bool {
+	for _, rename := range run.Renames { // check first, so a stale journal touches nothing
+		to := filepath.Join(baseDir, filepath.FromSlash(rename.To))
+		if _, err := fsys.Stat(to); err != nil {
+			result.addError("", fmt.Errorf("can not restore %s: %w", rename.From, err))
+			return false
+		}
+	}
+
+	for i := len(run.Renames) - 1; i >= 0; i-- {
+		rename := run.Renames[i]
+		job := Rename{
+			From: filepath.Join(baseDir, filepath.FromSlash(rename.To)),
+			To:   filepath.Join(baseDir, filepath.FromSlash(rename.From)),
+		}
+		err := fsys.Rename(job.From, job.To)
+		if err != nil {
+			result.addError("", err)
+			return false
+		}
+		result.Renames = append(result.Renames, job)
+		run.Renames = run.Renames[:i]
+	}
+
+	for len(run.HTML) > 0 {
+		html := run.HTML[0]
+		htmlFile := filepath.Join(baseDir, filepath.FromSlash(html.Path))
+		b, err := fsys.ReadFile(htmlFile)
+		if err != nil {
+			result.addError(htmlFile, err)
+			return false
+		}
+		fileContent := restoreReferences(htmlFile, string(b), html.Edits)
+		err = fsys.WriteFile(htmlFile, []byte(fileContent))
+		if err != nil {
+			result.addError(htmlFile, err)
+			return false
+		}
+		for _, edit := range html.Edits {
+			result.addEdit(htmlFile, edit.To, edit.From)
+		}
+		if sha256Hex(fileContent) != html.BeforeHash {
+			result.addError(htmlFile, errors.New("references were restored, but the file was edited since it was hashed"))
+		}
+		run.HTML = run.HTML[1:]
+	}
+	return true
+}
+
+// Replaces every reference in fileContent that an edit wrote with the value it replaced.
+// References are attribute values or srcset candidates in html files, and url() or @import targets in css files.
+func restoreReferences(filePath, fileContent string, edits []journalRename) string {
+	original := make(map[string]string) // [hashed value]original value
+	for _, edit := range edits {
+		original[edit.To] = edit.From
+	}
+
+	splices := []splice{}
+	if isCSSFile(filePath) {
+		for _, ref := range cssReferences(fileContent) {
+			if from, exists := original[fileContent[ref.valStart:ref.valEnd]]; exists {
+				splices = append(splices, splice{ref.valStart, ref.valEnd, from})
+			}
+		}
+		return applySplices(fileContent, splices)
+	}
+	for _, ti := range tagsFromHTML(fileContent) {
+		for _, attr := range ti.attrs {
+			values := []tagAttr{attr}
+			if srcsetAttrs[attr.name] {
+				values = srcsetURLs(attr)
+			}
+			for _, value := range values {
+				if from, exists := original[value.value]; exists && value.valStart != -1 {
+					splices = append(splices, splice{value.valStart, value.valEnd, from})
+				}
+			}
+		}
+	}
+	return applySplices(fileContent, splices)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
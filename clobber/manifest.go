@@ -0,0 +1,109 @@
+package clobber
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestEntry is where an asset was hashed to.
+type ManifestEntry struct {
+	Path      string   `json:"path"` // hashed path
+	Hash      string   `json:"hash"`
+	Integrity string   `json:"integrity,omitempty"` // subresource integrity value, none unless Options.SRI is set
+	Size      int64    `json:"size"`
+	HTML      []string `json:"html"` // html files referencing the asset
+}
+
+// Manifest is the json manifest a Run writes to Options.Manifest, mapping original asset paths to their hashed entry.
+// Paths are slash separated and relative to the scanned directory.
+type Manifest map[string]ManifestEntry
+
+// Builds the manifest of every rename in result, keyed by the original path of each asset, without any hash from an earlier run.
+// hashedRoot is the directory the hashed files were written to, either baseDir or the output directory.
+func buildManifest(result *Result, fsys FS, baseDir, hashedRoot string, names nameTemplate, h hasher) (Manifest, error) {
+	htmlFiles := make(map[string][]string) // [asset path]html files
+	for html, arr := range result.Edits {
+		for _, edit := range arr {
+			htmlFiles[edit.From] = appendUnique(htmlFiles[edit.From], html)
+		}
+	}
+
+	m := make(Manifest)
+	for _, job := range result.Renames {
+		from, err := relSlashPath(baseDir, job.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := relSlashPath(baseDir, job.To)
+		if err != nil {
+			return nil, err
+		}
+		info, err := fsys.Stat(filepath.Join(hashedRoot, filepath.FromSlash(to)))
+		if err != nil {
+			return nil, err
+		}
+
+		html := []string{}
+		for _, htmlFile := range htmlFiles[job.From] {
+			p, err := relSlashPath(baseDir, htmlFile)
+			if err != nil {
+				return nil, err
+			}
+			html = append(html, p)
+		}
+		sort.Strings(html)
+
+		original, _ := names.original(from, h)
+		m[original] = ManifestEntry{
+			Path:      to,
+			Hash:      job.Hash,
+			Integrity: job.Integrity,
+			Size:      info.Size(),
+			HTML:      html,
+		}
+	}
+	return m, nil
+}
+
+// Writes the manifest of result to manifestFile, adding to the manifest already there when merge is set.
+func writeManifest(result *Result, fsys FS, baseDir, hashedRoot, manifestFile string, names nameTemplate, h hasher, merge bool) error {
+	m, err := buildManifest(result, fsys, baseDir, hashedRoot, names, h)
+	if err != nil {
+		return err
+	}
+	if merge {
+		previous, err := ReadManifest(fsys, manifestFile)
+		if os.IsNotExist(err) {
+			previous, err = make(Manifest), nil
+		}
+		if err != nil {
+			return err
+		}
+		for original, entry := range m {
+			previous[original] = entry
+		}
+		m = previous
+	}
+	b, err := json.MarshalIndent(m, "", "  ") // map keys are sorted, so output is deterministic
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(manifestFile, append(b, '\n'))
+}
+
+// ReadManifest reads the manifest a Run wrote to manifestFile.
+func ReadManifest(fsys FS, manifestFile string) (Manifest, error) {
+	m := make(Manifest)
+	b, err := fsys.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", manifestFile, err)
+	}
+	return m, nil
+}
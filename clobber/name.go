@@ -0,0 +1,187 @@
+package clobber
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultNameTemplate names hashed files like app-cc123.js, used when Options.NameTemplate is empty.
+// {dir} is the directory of the file relative to the scanned directory, {name} its name without the extension,
+// {hash} the hash prefixed with cc and {ext} the extension with its dot. A template without {dir} is relative to the file's directory.
+const DefaultNameTemplate = "{name}-{hash}{ext}"
+
+var namePlaceholders = []string{"{dir}", "{name}", "{hash}", "{ext}"}
+
+// Names hashed files and recognises the names it gave before, so a file is re-hashed instead of gaining another hash.
+// The zero value is DefaultNameTemplate.
+type nameTemplate struct {
+	parts   []string         // literals and placeholders, starting with {dir}/, the placeholder of a directory and its slash
+	formats []*regexp.Regexp // match paths hashed in each of hashFormats, the hasher's own first
+	group   map[string]int   // [placeholder]submatch index
+}
+
+func newNameTemplate(template string, h hasher) (nameTemplate, error) {
+	if template == "" || template == DefaultNameTemplate {
+		return nameTemplate{}, nil // splitCCHash recognises the default names
+	}
+	if strings.HasPrefix(template, "/") || filepath.IsAbs(template) {
+		return nameTemplate{}, fmt.Errorf("name template %q must be relative", template)
+	}
+	if !strings.Contains(template, "{dir}") {
+		template = "{dir}/" + template
+	}
+
+	t := nameTemplate{group: make(map[string]int)}
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open == -1 {
+			t.parts = append(t.parts, rest)
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, rest[:open])
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return nameTemplate{}, fmt.Errorf("name template %q has an unclosed {", template)
+		}
+		placeholder := rest[open : open+end+1]
+		if !isNamePlaceholder(placeholder) {
+			return nameTemplate{}, fmt.Errorf("name template %q has unknown placeholder %s, want %s", template, placeholder, strings.Join(namePlaceholders, ", "))
+		}
+		if _, exists := t.group[placeholder]; exists {
+			return nameTemplate{}, fmt.Errorf("name template %q has %s more than once", template, placeholder)
+		}
+		t.group[placeholder] = len(t.group) + 1
+		rest = rest[open+end+1:]
+		if placeholder == "{dir}" && strings.HasPrefix(rest, "/") {
+			placeholder = "{dir}/" // matches nothing for files in the scanned directory itself
+			rest = rest[1:]
+		}
+		t.parts = append(t.parts, placeholder)
+	}
+	for _, required := range []string{"{name}", "{hash}"} {
+		if _, exists := t.group[required]; !exists {
+			return nameTemplate{}, fmt.Errorf("name template %q needs %s", template, required)
+		}
+	}
+
+	for _, format := range hashFormats(h) {
+		re, err := t.regexp(format.pattern())
+		if err != nil {
+			return nameTemplate{}, err
+		}
+		t.formats = append(t.formats, re)
+	}
+	return t, nil
+}
+
+func isNamePlaceholder(s string) bool {
+	for _, placeholder := range namePlaceholders {
+		if s == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the regexp matching slash separated paths relative to the scanned directory named by t, with hashes matching hashPattern.
+// Each placeholder is a submatch, numbered in t.group.
+func (t nameTemplate) regexp(hashPattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, part := range t.parts {
+		switch part {
+		case "{dir}/":
+			b.WriteString(`(?:(.+)/)?`)
+		case "{dir}":
+			b.WriteString(`(.+)`)
+		case "{name}":
+			b.WriteString(`([^/]+)`)
+		case "{hash}":
+			b.WriteString("(" + hashPattern + ")")
+		case "{ext}":
+			b.WriteString(`(\.[^./]*)?`)
+		default:
+			b.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Returns the name of the file at filePath hashed as ccHash, relative to the directory of filePath and slash separated.
+// Any hash filePath already has is replaced.
+func (t nameTemplate) hashedName(baseDir, filePath, ccHash string, h hasher) string {
+	if t.parts == nil {
+		_, fileName := filepath.Split(filePath)
+		name, _, ext := splitCCHash(fileName, h)
+		return name + "-" + ccHash + ext
+	}
+
+	rel, err := relSlashPath(baseDir, filePath)
+	if err != nil {
+		rel = filepath.ToSlash(filePath)
+	}
+	original, _ := t.original(rel, h)
+	base := path.Base(original)
+	ext := path.Ext(base)
+	values := map[string]string{
+		"{dir}/": path.Dir(original) + "/",
+		"{dir}":  path.Dir(original),
+		"{name}": base[:len(base)-len(ext)],
+		"{hash}": ccHash,
+		"{ext}":  ext,
+	}
+	var b strings.Builder
+	for _, part := range t.parts {
+		if value, isPlaceholder := values[part]; isPlaceholder {
+			b.WriteString(value)
+		} else {
+			b.WriteString(part)
+		}
+	}
+
+	hashed := path.Clean(b.String())
+	name, err := filepath.Rel(filepath.FromSlash(path.Dir(rel)), filepath.FromSlash(hashed))
+	if err != nil {
+		return path.Base(hashed)
+	}
+	return filepath.ToSlash(name)
+}
+
+// Returns the directory the file at filePath is hashed into, relative to its own and slash separated, "." when it stays.
+// Only the number of directories between them matters to references, so it is the same for any hash.
+func (t nameTemplate) movedDir(baseDir, filePath string, h hasher) string {
+	if t.parts == nil {
+		return "."
+	}
+	return path.Dir(t.hashedName(baseDir, filePath, "cc0", h))
+}
+
+// Returns the path rel had before t hashed it and the cc hash in rel, or rel and "" when it is not hashed.
+func (t nameTemplate) original(rel string, h hasher) (string, string) {
+	if t.parts == nil {
+		dir, fileName := path.Split(rel)
+		name, ccHash, ext := splitCCHash(fileName, h)
+		return dir + name + ext, ccHash
+	}
+	for _, re := range t.formats {
+		m := re.FindStringSubmatch(rel)
+		if m == nil {
+			continue
+		}
+		original := m[t.group["{name}"]]
+		if i, exists := t.group["{ext}"]; exists {
+			original += m[i]
+		}
+		if dir := m[t.group["{dir}"]]; dir != "" {
+			original = dir + "/" + original
+		}
+		return original, m[t.group["{hash}"]]
+	}
+	return rel, ""
+}
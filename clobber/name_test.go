@@ -0,0 +1,46 @@
+package clobber
+
+import "testing"
+
+func TestNameTemplate(t *testing.T) {
+	hex8 := hasher{algorithm: "sha256", encoding: "hex", length: 8}
+	tests := []struct {
+		template string
+		h        hasher
+		path     string // relative to the scanned directory "site"
+		expected string // relative to the directory of path
+	}{
+		{template: "", path: "js/app.js", expected: "app-cc1.js"},
+		{template: "", path: "js/app-cc123.js", expected: "app-cc1.js"},
+		{template: "{name}.{hash}{ext}", path: "js/app.js", expected: "app.cc1.js"},
+		{template: "{name}.{hash}{ext}", path: "js/app.cc123.js", expected: "app.cc1.js"},
+		{template: "{name}.{hash}{ext}", path: "js/app.min.js", expected: "app.min.cc1.js"},
+		{template: "{name}.{hash}{ext}", path: "js/app.min.cc123.js", expected: "app.min.cc1.js"},
+		{template: "{name}.{hash}{ext}", path: "LICENSE", expected: "LICENSE.cc1"},
+		{template: "{hash}/{name}{ext}", path: "js/app.js", expected: "cc1/app.js"},
+		{template: "{hash}/{name}{ext}", path: "js/cc123/app.js", expected: "../cc1/app.js"},
+		{template: "{hash}/{name}{ext}", path: "app.js", expected: "cc1/app.js"},
+		{template: "{hash}/{name}{ext}", path: "cc123/app.js", expected: "../cc1/app.js"},
+		{template: "static/{hash}/{dir}/{name}{ext}", path: "js/app.js", expected: "../static/cc1/js/app.js"},
+		{template: "static/{hash}/{dir}/{name}{ext}", path: "static/cc123/js/app.js", expected: "../../cc1/js/app.js"},
+		{template: "static/{hash}/{dir}/{name}{ext}", path: "app.js", expected: "static/cc1/app.js"},
+		{template: "{name}.{hash}{ext}", h: hex8, path: "app.ccab12cd34.js", expected: "app.cc1.js"},
+		{template: "{name}.{hash}{ext}", h: hex8, path: "app.ccab.ccab12cd34.js", expected: "app.ccab.cc1.js"},
+	}
+	for _, tt := range tests {
+		names, err := newNameTemplate(tt.template, tt.h)
+		if err != nil {
+			t.Errorf("newNameTemplate(%s): errored %s", tt.template, err)
+			continue
+		}
+		if actual := names.hashedName("site", "site/"+tt.path, "cc1", tt.h); actual != tt.expected {
+			t.Errorf("%q.hashedName(%s): expected %s, actual %s", tt.template, tt.path, tt.expected, actual)
+		}
+	}
+
+	for _, template := range []string{"{name}{ext}", "{hash}{ext}", "{name}-{hash}-{hash}{ext}", "{name}.{sha}{ext}", "{name}.{hash{ext}", "/{hash}/{name}{ext}"} {
+		if _, err := newNameTemplate(template, hasher{}); err == nil {
+			t.Errorf("newNameTemplate(%s): expected an error", template)
+		}
+	}
+}
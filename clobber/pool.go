@@ -0,0 +1,46 @@
+package clobber
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Returns the number of files Options.Jobs lets a run read, hash or write at once.
+func (opts Options) workers() int {
+	if opts.Jobs <= 0 {
+		return runtime.NumCPU()
+	}
+	return opts.Jobs
+}
+
+// Calls fn with every index below n, on at most workers goroutines at once, and returns once every call has.
+// fn stores what it produces at its own index, so results keep the order of their inputs.
+// Indexes not started by the time ctx is done are skipped, and ctx's error is returned.
+func forEach(ctx context.Context, workers, n int, fn func(i int)) error {
+	if workers > n {
+		workers = n
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n; i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return err
+}
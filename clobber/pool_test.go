@@ -0,0 +1,43 @@
+package clobber
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForEach(t *testing.T) {
+	for _, workers := range []int{1, 3, 100} {
+		squares := make([]int, 50)
+		var running, most int32
+		err := forEach(context.Background(), workers, len(squares), func(i int) {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&most)
+				if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+					break
+				}
+			}
+			squares[i] = i * i
+			atomic.AddInt32(&running, -1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, square := range squares {
+			if square != i*i {
+				t.Errorf("%d workers: index %d was not called", workers, i)
+			}
+		}
+		if int(most) > workers {
+			t.Errorf("%d workers: %d calls ran at once", workers, most)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	if err := forEach(ctx, 2, 10, func(int) { calls++ }); err != context.Canceled || calls != 0 {
+		t.Errorf("cancelled: expected %v and no calls, actual %v and %d calls", context.Canceled, err, calls)
+	}
+}
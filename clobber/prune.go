@@ -0,0 +1,161 @@
+package clobber
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Prune removes stale generations of hashed assets from where a Run wrote them, opts.OutDir when set, else opts.Dir.
+// A hashed file is kept when the html there references it, directly or through a kept css file,
+// when an entry of opts.Manifest names it, or when it is one of the opts.Keep newest generations of its asset.
+// Hashed files are recognised by the hash settings and NameTemplate of opts, and only files selected by opts.Include and opts.Exclude are removed.
+// On a dry run the stale files are listed in Result.Removed, but left in place.
+// The returned error is for invalid options or a cancelled ctx, errors with single files are in Result.Errors.
+func Prune(ctx context.Context, opts Options) (*Result, error) {
+	h, names, baseDir, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+	fsys := opts.fs()
+	hashedRoot := baseDir
+	if opts.OutDir != "" {
+		hashedRoot = opts.OutDir
+	}
+
+	result := newResult()
+	result.DryRun = opts.DryRun
+
+	filter, err := newPathFilter(fsys, hashedRoot, opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	generations, err := hashedGenerations(fsys, hashedRoot, filter, names, h)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = DefaultRules
+	}
+	graph := newAssetGraph(fsys, filter, rules)
+	htmlFilePaths, err := htmlFilePaths(fsys, hashedRoot, "", filter)
+	if err != nil {
+		return nil, err
+	}
+	err = graph.addHTMLFiles(ctx, result, htmlFilePaths, opts.workers())
+	if err != nil {
+		return nil, err
+	}
+	if result.errorCount() != 0 {
+		return result, nil // the references of an html file are unknown, so nothing is removed
+	}
+
+	kept := make(map[string]bool)
+	for _, arr := range generations {
+		for i, gen := range arr {
+			if i < opts.Keep {
+				kept[gen] = true
+			}
+		}
+	}
+	if opts.Manifest != "" {
+		m, err := ReadManifest(fsys, opts.Manifest)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range m {
+			kept[filepath.Join(hashedRoot, filepath.FromSlash(entry.Path))] = true
+		}
+	}
+	keepReferenced(result, graph, kept)
+
+	for _, arr := range generations {
+		for _, gen := range arr {
+			if kept[gen] {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if !opts.DryRun {
+				err := fsys.Remove(gen)
+				if err != nil {
+					result.addError("", err)
+					continue
+				}
+			}
+			result.Removed = append(result.Removed, gen)
+		}
+	}
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+// Returns every hashed file under root selected by filter, grouped by the path of the asset they were hashed from,
+// each group newest first.
+func hashedGenerations(fsys FS, root string, filter *pathFilter, names nameTemplate, h hasher) (map[string][]string, error) {
+	generations := make(map[string][]string) // [original path]hashed files
+	modTimes := make(map[string]int64)       // [hashed file]modification time
+	err := fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && filter.excludedDir(path) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !filter.selected(path) {
+			return nil
+		}
+		rel, err := relSlashPath(root, path)
+		if err != nil {
+			return err
+		}
+		if original, ccHash := names.original(rel, h); ccHash != "" {
+			generations[original] = append(generations[original], path)
+			modTimes[path] = info.ModTime().UnixNano()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, arr := range generations {
+		sort.Slice(arr, func(i, j int) bool {
+			if modTimes[arr[i]] != modTimes[arr[j]] {
+				return modTimes[arr[i]] > modTimes[arr[j]]
+			}
+			return arr[i] > arr[j]
+		})
+	}
+	return generations, nil
+}
+
+// Adds every file the html in graph references to kept, and the files referenced by kept css files, until none are left.
+func keepReferenced(result *Result, graph *assetGraph, kept map[string]bool) {
+	for {
+		for _, arr := range graph.refs {
+			for _, ref := range arr {
+				kept[ref.path] = true
+			}
+		}
+		added := false
+		for path := range kept {
+			if _, read := graph.scanned[path]; read || !isCSSFile(path) {
+				continue
+			}
+			b, err := graph.fs.ReadFile(path)
+			graph.scanned[path] = string(b)
+			if err != nil {
+				continue
+			}
+			addCSSJobs(result, graph, path, string(b))
+			added = true
+		}
+		if !added {
+			return
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package clobber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type job struct {
+	fileNameWantToRename string
+	filePathWantToRename string
+	renameTo             string // relative to the directory of filePathWantToRename, slash separated
+	tagPath              string
+	rebase               string // directory htmlFile moves to when it is hashed itself, relative to its own, none when empty
+	wholeTag             string
+	htmlFile             string
+	hash                 string // cc hash in renameTo
+	startTag             int    // offset of wholeTag in htmlFile
+	valStart             int    // offset of the attribute value in htmlFile
+	valEnd               int
+	attrs                map[string]tagAttr // of the tag, offsets in htmlFile
+	selfClosing          bool
+	integrity            string // subresource integrity value to set on the tag, none when empty
+	assetIntegrity       string // subresource integrity value of the asset whatever the tag, for the manifest
+	crossOrigin          string // crossorigin value to add with integrity, none when empty
+}
+
+// Renames every file and edits every html file as one transaction, writing workers html files at once.
+// Everything is staged in memory first, then on any error every completed rename and html write is rolled back.
+func renameAll(result *Result, fsys FS, jobs []*job, scanned map[string]string, workers int) {
+	renames := planRenames(jobs)
+	errorCount := result.errorCount()
+	writes := planHTMLWrites(result, fsys, jobs, scanned)
+	if result.errorCount() != errorCount {
+		return // an html file could not be read, nothing has been touched yet
+	}
+
+	tx := &transaction{fs: fsys}
+	for _, job := range renames {
+		if job.From == job.To {
+			continue // already hashed with the current contents
+		}
+		err := fsys.Rename(job.From, job.To)
+		if err != nil {
+			result.addError(job.HTMLFile, err)
+			tx.rollback(result)
+			return
+		}
+		tx.renamed = append(tx.renamed, job)
+	}
+
+	tx.written = append(tx.written, writes...) // before writing, a failed write may have truncated the file
+	writeErrors := make([]error, len(writes))
+	forEach(context.Background(), workers, len(writes), func(i int) {
+		writeErrors[i] = fsys.WriteFile(writes[i].target, []byte(writes[i].after))
+	})
+	for i, err := range writeErrors {
+		if err != nil {
+			result.addError(writes[i].htmlFile, err)
+		}
+	}
+	if result.errorCount() != errorCount {
+		tx.rollback(result)
+		return
+	}
+
+	result.Renames = append(result.Renames, renames...)
+	result.writes = append(result.writes, writes...)
+	for _, write := range writes {
+		for _, job := range write.jobs {
+			result.addEdit(job.htmlFile, job.filePathWantToRename, job.renameTo)
+		}
+	}
+}
+
+// Renames and html writes completed by renameAll.
+type transaction struct {
+	fs      FS
+	renamed []Rename
+	written []htmlWrite
+}
+
+// Undoes every html write and rename in reverse order.
+// Errors while rolling back are added to result, there is nothing more to undo them with.
+func (tx *transaction) rollback(result *Result) {
+	for i := len(tx.written) - 1; i >= 0; i-- {
+		write := tx.written[i]
+		err := tx.fs.WriteFile(write.target, []byte(write.before))
+		if err != nil {
+			result.addError(write.htmlFile, fmt.Errorf("rolling back edits: %w", err))
+		}
+	}
+	for i := len(tx.renamed) - 1; i >= 0; i-- {
+		job := tx.renamed[i]
+		err := tx.fs.Rename(job.To, job.From)
+		if err != nil {
+			result.addError(job.HTMLFile, fmt.Errorf("rolling back rename of %s: %w", job.From, err))
+		}
+	}
+	result.addError("", errors.New("rolled back every rename and html edit"))
+}
+
+// Does everything renameAll would, or copyAll when outDir is set, except writing to fsys.
+// Every html edit is recorded in result.Diffs as a unified diff against the file it would be written to.
+func planAll(result *Result, fsys FS, baseDir, outDir string, jobs []*job, scanned map[string]string, copyRest func(path string, info os.FileInfo) bool) {
+	copied := make(map[string]bool) // paths that would be written to outDir
+	for _, job := range planRenames(jobs) {
+		if outDir != "" {
+			hashedOut, originalOut, err := renameOutPaths(baseDir, outDir, job)
+			if err != nil {
+				result.addError(job.HTMLFile, err)
+				continue
+			}
+			result.outPaths[job.To], result.outPaths[job.From] = hashedOut, originalOut
+			result.Copied = append(result.Copied, job.From)
+			copied[job.From] = true
+		}
+		result.Renames = append(result.Renames, job)
+	}
+
+	for _, write := range planHTMLWrites(result, fsys, jobs, scanned) {
+		target := write.target
+		if outDir != "" {
+			outPath, err := mirrorPath(baseDir, outDir, write.target)
+			if err != nil {
+				result.addError(write.htmlFile, err)
+				continue
+			}
+			target = outPath
+			copied[filepath.Clean(write.target)] = true
+		}
+		result.writes = append(result.writes, write)
+		result.Diffs[write.htmlFile] = unifiedDiff(write.htmlFile, target, write.before, write.after)
+		for _, job := range write.jobs {
+			result.addEdit(job.htmlFile, job.filePathWantToRename, job.renameTo)
+		}
+	}
+
+	if outDir == "" {
+		return
+	}
+	rest, err := restFiles(fsys, baseDir, outDir, copied, copyRest)
+	if err != nil {
+		result.addError("", err)
+		return
+	}
+	for _, path := range rest {
+		outPath, err := mirrorPath(baseDir, outDir, path)
+		if err != nil {
+			result.addError("", err)
+			continue
+		}
+		result.outPaths[path] = outPath
+		result.Copied = append(result.Copied, path)
+	}
+	sort.Strings(result.Copied)
+}
+
+// Does everything renameAll would, but into outDir instead of in place, writing workers files at once.
+// Hashed assets and edited html are written to their place in outDir, every other file under baseDir copyRest reports true for is copied as is,
+// the originals of hashed assets included, for references the rules do not select.
+func copyAll(result *Result, fsys FS, baseDir, outDir string, jobs []*job, scanned map[string]string, workers int, copyRest func(path string, info os.FileInfo) bool) {
+	copied := make(map[string]bool) // paths already written to outDir
+
+	renames := planRenames(jobs)
+	hashedOuts := make([]string, len(renames))
+	originalOuts := make([]string, len(renames))
+	renameErrors := make([]error, len(renames))
+	forEach(context.Background(), workers, len(renames), func(i int) {
+		var b []byte
+		hashedOut, originalOut, err := renameOutPaths(baseDir, outDir, renames[i])
+		if err == nil {
+			b, err = fsys.ReadFile(renames[i].From)
+		}
+		if err == nil {
+			err = fsys.WriteFile(hashedOut, b)
+		}
+		if err == nil {
+			err = fsys.WriteFile(originalOut, b)
+		}
+		hashedOuts[i], originalOuts[i], renameErrors[i] = hashedOut, originalOut, err
+	})
+	for i, job := range renames {
+		copied[job.From] = true
+		if renameErrors[i] != nil {
+			result.addError(job.HTMLFile, renameErrors[i])
+			continue
+		}
+		result.outPaths[job.To], result.outPaths[job.From] = hashedOuts[i], originalOuts[i]
+		result.Copied = append(result.Copied, job.From)
+		result.Renames = append(result.Renames, job)
+	}
+
+	writes := planHTMLWrites(result, fsys, jobs, scanned)
+	writeErrors := make([]error, len(writes))
+	forEach(context.Background(), workers, len(writes), func(i int) {
+		outPath, err := mirrorPath(baseDir, outDir, writes[i].target)
+		if err == nil {
+			err = fsys.WriteFile(outPath, []byte(writes[i].after))
+		}
+		writeErrors[i] = err
+	})
+	for i, write := range writes {
+		copied[filepath.Clean(write.target)] = true
+		if writeErrors[i] != nil {
+			result.addError(write.htmlFile, writeErrors[i])
+			continue
+		}
+		for _, job := range write.jobs {
+			result.addEdit(job.htmlFile, job.filePathWantToRename, job.renameTo)
+		}
+	}
+
+	rest, err := restFiles(fsys, baseDir, outDir, copied, copyRest)
+	if err != nil {
+		result.addError("", err)
+		return
+	}
+	copyOutPaths := make([]string, len(rest))
+	copyErrors := make([]error, len(rest))
+	forEach(context.Background(), workers, len(rest), func(i int) {
+		outPath, err := mirrorPath(baseDir, outDir, rest[i])
+		if err == nil {
+			err = copyFile(fsys, rest[i], outPath)
+		}
+		copyOutPaths[i], copyErrors[i] = outPath, err
+	})
+	for i, err := range copyErrors {
+		if err != nil {
+			result.addError("", err)
+			continue
+		}
+		result.outPaths[rest[i]] = copyOutPaths[i]
+		result.Copied = append(result.Copied, rest[i])
+	}
+	sort.Strings(result.Copied)
+}
+
+// Returns where the hashed copy of the renamed asset belongs in outDir, and where the copy of its original does.
+func renameOutPaths(baseDir, outDir string, job Rename) (hashedOut, originalOut string, err error) {
+	hashedOut, err = mirrorPath(baseDir, outDir, job.To)
+	if err != nil {
+		return "", "", err
+	}
+	originalOut, err = mirrorPath(baseDir, outDir, job.From)
+	return hashedOut, originalOut, err
+}
+
+// Returns the files under baseDir, outside of outDir, that copyRest reports true for and are not in copied.
+func restFiles(fsys FS, baseDir, outDir string, copied map[string]bool, copyRest func(path string, info os.FileInfo) bool) ([]string, error) {
+	rest := []string{}
+	err := fsys.Walk(baseDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if samePath(path, outDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !copied[filepath.Clean(path)] && copyRest(path, info) {
+				rest = append(rest, path)
+			}
+			return nil
+		})
+	return rest, err
+}
+
+// Returns where path under baseDir belongs in outDir.
+func mirrorPath(baseDir, outDir, path string) (string, error) {
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s, it can not be copied to %s", path, baseDir, outDir)
+	}
+	return filepath.Join(outDir, rel), nil
+}
+
+func copyFile(fsys FS, from, to string) error {
+	b, err := fsys.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(to, b)
+}
+
+func samePath(a, b string) bool {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return absA == absB
+}
+
+// Returns one Rename per file, sorted by pathFrom.
+func planRenames(jobs []*job) []Rename {
+	for _, job := range jobs {
+		job.filePathWantToRename = filepath.Clean(job.filePathWantToRename)
+	}
+
+	byFrom := make(map[string]Rename)
+	for _, job := range jobs {
+		dir, _ := filepath.Split(job.filePathWantToRename)
+		byFrom[job.filePathWantToRename] = Rename{
+			From:      job.filePathWantToRename,
+			To:        filepath.Join(dir, filepath.FromSlash(job.renameTo)),
+			HTMLFile:  job.htmlFile,
+			Hash:      job.hash,
+			Integrity: job.assetIntegrity,
+		}
+	} // only want to rename a file once, else we may attempt to rename an already renamed file, and it won't exist
+
+	planned := make([]Rename, 0, len(byFrom))
+	for _, job := range byFrom {
+		planned = append(planned, job)
+	}
+	sort.Slice(planned, func(i, j int) bool {
+		return planned[i].From < planned[j].From
+	})
+	return planned
+}
+
+type htmlWrite struct {
+	htmlFile string // html or css file read
+	target   string // path written to, where htmlFile is renamed to if it is an asset itself
+	before   string
+	after    string
+	jobs     []*job
+}
+
+// Applies the jobs of every html and css file they edit in memory.
+// Files are taken from scanned, the contents read while scanning, and only read when missing from it.
+func planHTMLWrites(result *Result, fsys FS, jobs []*job, scanned map[string]string) []htmlWrite {
+	renamedTo := make(map[string]string)
+	for _, job := range planRenames(jobs) {
+		renamedTo[job.From] = job.To
+	}
+
+	writes := []htmlWrite{}
+	htmlFiles, htmlJobs := jobsByHTMLFile(jobs)
+	for _, htmlFile := range htmlFiles {
+		fileContent, read := scanned[htmlFile]
+		if !read {
+			b, err := fsys.ReadFile(htmlFile)
+			if err != nil {
+				result.addError(htmlFile, err)
+				continue
+			}
+			fileContent = string(b)
+		}
+		target := htmlFile
+		if pathTo, renamed := renamedTo[filepath.Clean(htmlFile)]; renamed {
+			target = pathTo
+		}
+		writes = append(writes, htmlWrite{
+			htmlFile: htmlFile,
+			target:   target,
+			before:   fileContent,
+			after:    applyJobs(fileContent, htmlJobs[htmlFile]),
+			jobs:     htmlJobs[htmlFile],
+		})
+	}
+	return writes
+}
+
+// Groups jobs by the html file they edit, keeping the order html files were first seen in.
+func jobsByHTMLFile(jobs []*job) ([]string, map[string][]*job) {
+	htmlFiles := []string{}
+	htmlJobs := make(map[string][]*job)
+	for _, job := range jobs {
+		if _, exists := htmlJobs[job.htmlFile]; !exists {
+			htmlFiles = append(htmlFiles, job.htmlFile)
+		}
+		htmlJobs[job.htmlFile] = append(htmlJobs[job.htmlFile], job)
+	}
+	return htmlFiles, htmlJobs
+}
+
+// Applies the splices of every job to fileContent.
+// Jobs may edit the same tag, like one per srcset candidate, since every splice is against the scanned content.
+func applyJobs(fileContent string, jobs []*job) string {
+	splices := []splice{}
+	for _, job := range jobs {
+		end := job.startTag + len(job.wholeTag)
+		if end > len(fileContent) || fileContent[job.startTag:end] != job.wholeTag {
+			continue // html changed since it was scanned
+		}
+		splices = append(splices, jobSplices(job)...)
+	}
+	return applySplices(fileContent, splices)
+}
+
+// Returns the splices pointing the tag of j at the renamed file, offsets are in the scanned content.
+// When the job has an integrity value, the integrity attribute is updated or added, and crossorigin added if missing.
+func jobSplices(j *job) []splice {
+	splices := []splice{{j.valStart, j.valEnd, j.newValue()}}
+	if j.integrity == "" {
+		return splices
+	}
+
+	insertAt := j.startTag + len(j.wholeTag) - len(">")
+	if j.selfClosing {
+		insertAt -= len("/")
+	}
+	if attr, exists := j.attrs["integrity"]; exists && attr.valStart != -1 {
+		splices = append(splices, splice{attr.valStart, attr.valEnd, j.integrity})
+	} else if exists {
+		splices = append(splices, splice{attr.nameEnd, attr.nameEnd, `="` + j.integrity + `"`}) // a second attribute would be ignored
+	} else {
+		splices = append(splices, splice{insertAt, insertAt, ` integrity="` + j.integrity + `"`})
+	}
+	if _, exists := j.attrs["crossorigin"]; !exists && j.crossOrigin != "" {
+		splices = append(splices, splice{insertAt, insertAt, ` crossorigin="` + j.crossOrigin + `"`})
+	}
+	return splices
+}
+
+// Returns the attribute value pointing at the renamed file, from where htmlFile ends up.
+func (j *job) newValue() string {
+	value := j.tagPath + j.renameTo
+	if strings.Contains(j.renameTo, "/") {
+		value = path.Clean(value) // renamed into another directory
+	}
+	if j.rebase != "" {
+		rel, err := filepath.Rel(filepath.FromSlash(j.rebase), filepath.FromSlash(value))
+		if err == nil {
+			value = filepath.ToSlash(rel)
+		}
+	}
+	return value
+}
+
+// Replacement of s[start:end] with value.
+type splice struct {
+	start, end int
+	value      string
+}
+
+// Applies splices to s, all offsets are into the original s.
+// Splices at the same offset end up in the order given.
+func applySplices(s string, splices []splice) string {
+	order := make([]int, len(splices))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { // from the end of s, so earlier offsets stay valid
+		sa, sb := splices[order[a]], splices[order[b]]
+		if sa.start != sb.start {
+			return sa.start > sb.start
+		}
+		return order[a] > order[b]
+	})
+	for _, i := range order {
+		s = s[:splices[i].start] + splices[i].value + s[splices[i].end:]
+	}
+	return s
+}
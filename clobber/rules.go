@@ -0,0 +1,209 @@
+package clobber
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Rule selects references to hash: the Attr attribute of Tag elements, when the referenced file has one of Extensions.
+type Rule struct {
+	Tag        string   `json:"tag"`                  // element name, e.g. img
+	Attr       string   `json:"attr"`                 // attribute holding the reference, e.g. src
+	Rel        string   `json:"rel,omitempty"`        // only tags whose rel attribute has this token, any tag when empty
+	Extensions []string `json:"extensions,omitempty"` // extensions of the referenced files, e.g. .js, any extension when empty
+}
+
+// DefaultRules hash the js of script tags and the css of link tags, used when Options.Rules is nil.
+var DefaultRules = []Rule{
+	{Tag: "script", Attr: "src", Extensions: []string{".js"}},
+	{Tag: "link", Attr: "href", Extensions: []string{".css"}},
+}
+
+// ExtendedRules are DefaultRules plus modules, wasm, images and srcsets, fonts, media posters, icons and web app manifests.
+var ExtendedRules = []Rule{
+	{Tag: "script", Attr: "src", Extensions: []string{".js", ".mjs"}},
+	{Tag: "link", Attr: "href", Extensions: []string{".css"}},
+	{Tag: "link", Attr: "href", Rel: "icon", Extensions: imageExtensions},
+	{Tag: "link", Attr: "href", Rel: "apple-touch-icon", Extensions: imageExtensions},
+	{Tag: "link", Attr: "href", Rel: "manifest", Extensions: []string{".json", ".webmanifest"}},
+	{Tag: "link", Attr: "href", Rel: "modulepreload", Extensions: []string{".js", ".mjs"}},
+	{Tag: "link", Attr: "href", Rel: "preload", Extensions: append(append([]string{".js", ".mjs", ".wasm"}, fontExtensions...), imageExtensions...)},
+	{Tag: "link", Attr: "imagesrcset", Rel: "preload", Extensions: imageExtensions},
+	{Tag: "img", Attr: "src", Extensions: imageExtensions},
+	{Tag: "img", Attr: "srcset", Extensions: imageExtensions},
+	{Tag: "source", Attr: "src", Extensions: append(append([]string{}, imageExtensions...), mediaExtensions...)},
+	{Tag: "source", Attr: "srcset", Extensions: imageExtensions},
+	{Tag: "video", Attr: "src", Extensions: mediaExtensions},
+	{Tag: "video", Attr: "poster", Extensions: imageExtensions},
+	{Tag: "audio", Attr: "src", Extensions: mediaExtensions},
+	{Tag: "input", Attr: "src", Extensions: imageExtensions},
+}
+
+// Attributes that can reference a local file whatever the rules, kept unhashed when the rules do not select them.
+var referenceRules = []Rule{
+	{Tag: "a", Attr: "href"},
+	{Tag: "area", Attr: "href"},
+	{Tag: "audio", Attr: "src"},
+	{Tag: "embed", Attr: "src"},
+	{Tag: "iframe", Attr: "src"},
+	{Tag: "img", Attr: "src"},
+	{Tag: "img", Attr: "srcset"},
+	{Tag: "input", Attr: "src"},
+	{Tag: "link", Attr: "href"},
+	{Tag: "link", Attr: "imagesrcset"},
+	{Tag: "object", Attr: "data"},
+	{Tag: "script", Attr: "src"},
+	{Tag: "source", Attr: "src"},
+	{Tag: "source", Attr: "srcset"},
+	{Tag: "track", Attr: "src"},
+	{Tag: "video", Attr: "src"},
+	{Tag: "video", Attr: "poster"},
+}
+
+var (
+	imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico"}
+	fontExtensions  = []string{".woff", ".woff2", ".ttf", ".otf", ".eot"}
+	mediaExtensions = []string{".mp4", ".webm", ".ogg", ".mp3", ".wav"}
+)
+
+// ParseRules parses rules written as tag[attr], optionally followed by [rel=token] and :extension,extension,
+// e.g. img[src]:.png,.jpg or link[href][rel=icon]:.ico. The names default and extended stand for DefaultRules and ExtendedRules.
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, spec := range specs {
+		switch spec {
+		case "default":
+			rules = append(rules, DefaultRules...)
+			continue
+		case "extended":
+			rules = append(rules, ExtendedRules...)
+			continue
+		}
+		rule, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(spec string) (Rule, error) {
+	rule := Rule{}
+	selector := spec
+	if i := strings.Index(spec, ":"); i != -1 {
+		selector = spec[:i]
+		for _, ext := range strings.Split(spec[i+1:], ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				rule.Extensions = append(rule.Extensions, "."+strings.TrimPrefix(ext, "."))
+			}
+		}
+	}
+
+	i := strings.Index(selector, "[")
+	if i == -1 {
+		return Rule{}, fmt.Errorf("rule %q has no [attribute]", spec)
+	}
+	rule.Tag = strings.ToLower(strings.TrimSpace(selector[:i]))
+	for rest := selector[i:]; rest != ""; {
+		end := strings.Index(rest, "]")
+		if rest[0] != '[' || end == -1 {
+			return Rule{}, fmt.Errorf("rule %q: expected [attribute] or [rel=token] at %q", spec, rest)
+		}
+		inner := strings.TrimSpace(rest[1:end])
+		if name := strings.TrimPrefix(inner, "rel="); name != inner {
+			rule.Rel = strings.ToLower(strings.Trim(name, `"'`))
+		} else {
+			rule.Attr = strings.ToLower(inner)
+		}
+		rest = rest[end+1:]
+	}
+	if rule.Tag == "" || rule.Attr == "" {
+		return Rule{}, fmt.Errorf("rule %q needs both a tag and an attribute", spec)
+	}
+	return rule, nil
+}
+
+// Reference to an asset in an html tag.
+type htmlRef struct {
+	ti   tagInfo // the tag, or for a srcset candidate a tag of type srcset holding only its url
+	attr tagAttr
+}
+
+// Returns the references in the tag ti that rules select, each once in rule order.
+// Every candidate of a srcset attribute is a reference of its own, checked against the rules by itself.
+func selectedRefs(rules []Rule, ti tagInfo) []htmlRef {
+	refs := []htmlRef{}
+	selected := make(map[int]bool) // [valStart]already selected
+	for _, rule := range rules {
+		if rule.Tag != ti.tagType {
+			continue
+		}
+		if rule.Rel != "" && !hasToken(ti.attrs["rel"].value, rule.Rel) {
+			continue
+		}
+		attr, exists := ti.attrs[rule.Attr]
+		if !exists || attr.valStart == -1 {
+			continue
+		}
+
+		candidates := []htmlRef{{ti, attr}}
+		if srcsetAttrs[rule.Attr] {
+			candidates = candidates[:0]
+			for _, url := range srcsetURLs(attr) {
+				candidate := tagInfo{tagType: "srcset", wholeTag: url.value, startTag: url.valStart}
+				candidates = append(candidates, htmlRef{candidate, url})
+			}
+		}
+		for _, ref := range candidates {
+			if !selected[ref.attr.valStart] && rule.allows(ref.attr.value) {
+				selected[ref.attr.valStart] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// Returns the references in the tag ti to local files that rules do not select, which keep pointing at the unhashed file.
+func unselectedRefs(rules []Rule, ti tagInfo) []htmlRef {
+	selected := make(map[int]bool) // [valStart]selected by rules
+	for _, ref := range selectedRefs(rules, ti) {
+		selected[ref.attr.valStart] = true
+	}
+	refs := []htmlRef{}
+	for _, ref := range selectedRefs(referenceRules, ti) {
+		if !selected[ref.attr.valStart] {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// Reports whether value references a local file with one of the rule's extensions.
+func (r Rule) allows(value string) bool {
+	if value == "" || httpPrefixed(value) {
+		return false
+	}
+	if len(r.Extensions) == 0 {
+		return true
+	}
+	ext := filepath.Ext(value)
+	for _, allowed := range r.Extensions {
+		if strings.EqualFold(ext, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the space separated list s has token, ignoring case.
+func hasToken(s, token string) bool {
+	for _, field := range strings.Fields(s) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package clobber
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectedRefs(t *testing.T) {
+	tests := []struct {
+		in       string
+		rules    []Rule
+		expected []string
+	}{
+		{`<script src='./single.js'></script>`, DefaultRules, []string{"src"}},
+		{`<script type="text/javascript" src="../lame.js"></script>`, DefaultRules, []string{"src"}},
+		{`<script src=""></script>`, DefaultRules, []string{}},
+		{`<script src="  "></script>`, DefaultRules, []string{}},
+		{`<script type="text/javascript" src="../bad.php"></script>`, DefaultRules, []string{}},
+		{`<script src="./big.css"></script>`, DefaultRules, []string{}},
+		{`<script src="https://code.jquery.com/jquery-3.5.1.min.js"></script>`, DefaultRules, []string{}},
+		{`<link href='./single.css'>`, DefaultRules, []string{"href"}},
+		{`<link href="./BIG.CSS">`, DefaultRules, []string{"href"}},
+		{`<link href="" rel="stylesheet">`, DefaultRules, []string{}},
+		{`<link href="./big.js">`, DefaultRules, []string{}},
+		{`<link href="https://fonts.googleapis.com/css?family=Bowlby+One+SC|Cabin&display=swap" rel="stylesheet">`, DefaultRules, []string{}},
+		{`<link rel="icon" href="favicon.png">`, DefaultRules, []string{}},
+		{`<link rel="shortcut icon" href="favicon.png">`, ExtendedRules, []string{"href"}},
+		{`<link rel="stylesheet" href="favicon.png">`, ExtendedRules, []string{}},
+		{`<link rel="manifest" href="site.webmanifest">`, ExtendedRules, []string{"href"}},
+		{`<script type="module" src="app.mjs"></script>`, ExtendedRules, []string{"src"}},
+		{`<img src="logo.svg" alt="">`, ExtendedRules, []string{"src"}},
+		{`<video src="intro.webm" poster="intro.jpg"></video>`, ExtendedRules, []string{"src", "poster"}},
+		{`<img src="logo.svg">`, []Rule{{Tag: "img", Attr: "src"}}, []string{"src"}},
+		{`<img src="a.png" srcset="a.png 1x, https://cdn/b.png 2x, c.gif 3x">`, ExtendedRules, []string{"src", "srcset", "srcset"}},
+	}
+	for _, tt := range tests {
+		tags := tagsFromHTML(tt.in)
+		if len(tags) == 0 {
+			t.Fatalf("tagsFromHTML(%s): no tags", tt.in)
+		}
+		actual := []string{}
+		for _, ref := range selectedRefs(tt.rules, tags[0]) {
+			actual = append(actual, ref.attr.name)
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("selectedRefs(%s): expected attributes %v, actual %v", tt.in, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []Rule
+	}{
+		{"img[src]:.png,jpg", []Rule{{Tag: "img", Attr: "src", Extensions: []string{".png", ".jpg"}}}},
+		{"link[href][rel=icon]:.ico", []Rule{{Tag: "link", Attr: "href", Rel: "icon", Extensions: []string{".ico"}}}},
+		{"LINK[rel='manifest'][HREF]", []Rule{{Tag: "link", Attr: "href", Rel: "manifest"}}},
+		{"default", DefaultRules},
+	}
+	for _, tt := range tests {
+		actual, err := ParseRules([]string{tt.in})
+		if err != nil {
+			t.Errorf("ParseRules(%s): errored %s", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("ParseRules(%s): expected %+v, actual %+v", tt.in, tt.expected, actual)
+		}
+	}
+
+	for _, in := range []string{"img", "[src]", "img[rel=icon]", "img[src", "img[src]x"} {
+		if _, err := ParseRules([]string{in}); err == nil {
+			t.Errorf("ParseRules(%s): expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package clobber
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Cache-Control values of the files FileServer serves.
+const (
+	ImmutableCacheControl  = "public, max-age=31536000, immutable" // hashed files, which never change
+	RevalidateCacheControl = "no-cache"                            // every other file, html included
+)
+
+// FileServer returns a handler serving the files under opts.OutDir, or opts.Dir when it is empty, as a Run left them.
+// Files hashed with the hash settings and NameTemplate of opts are cached for a year as immutable, with their cc hash as ETag.
+// Every other file is revalidated on each use, through an ETag of its size and modification time.
+// A directory is served as its index.html, journals, caches and ignore files are not served.
+// Only Dir, OutDir, FS and the naming options of opts are used.
+func FileServer(opts Options) (http.Handler, error) {
+	h, names, root, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if opts.OutDir != "" {
+		root = opts.OutDir
+	}
+	return &fileServer{fs: opts.fs(), root: root, names: names, h: h}, nil
+}
+
+type fileServer struct {
+	fs    FS
+	root  string
+	names nameTemplate
+	h     hasher
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	name := filepath.Join(s.root, filepath.FromSlash(rel))
+	info, err := s.fs.Stat(name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		rel = path.Join(rel, "index.html")
+		name = filepath.Join(name, "index.html")
+		info, err = s.fs.Stat(name)
+	}
+	if err != nil || info.IsDir() || isToolFile(info.Name()) {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := s.fs.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, ccHash := s.names.original(rel, s.h)
+	serveFile(w, r, info, b, ccHash, ccHash != "")
+}
+
+// Reports whether a file named name is kept by the tool itself rather than served.
+func isToolFile(name string) bool {
+	return name == JournalFileName || name == CacheFileName || name == IgnoreFileName
+}
+
+// Serves b, the contents of the file with the stat info, cached as immutable or revalidated on each use.
+// The ETag is ccHash, or the size and modification time of the file when it is empty.
+// Conditional and range requests are answered by http.ServeContent.
+func serveFile(w http.ResponseWriter, r *http.Request, info os.FileInfo, b []byte, ccHash string, immutable bool) {
+	if immutable {
+		w.Header().Set("Cache-Control", ImmutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", RevalidateCacheControl)
+	}
+	if ccHash != "" {
+		w.Header().Set("ETag", `"`+ccHash+`"`)
+	} else {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(b))
+}
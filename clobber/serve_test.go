@@ -0,0 +1,80 @@
+package clobber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"dist/index.html":         []byte(`<script src="js/app-cc123.js"></script>`),
+		"dist/js/app-cc123.js":    []byte(`console.log("hashed")`),
+		"dist/js/access.js":       []byte(`console.log("not hashed")`),
+		"dist/docs/index.html":    []byte("docs"),
+		"dist/" + CacheFileName:   []byte("{}"),
+		"dist/" + JournalFileName: []byte("{}"),
+	})
+	server, err := FileServer(Options{Dir: "src", OutDir: "dist", FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for key := range header {
+			r.Header.Set(key, header.Get(key))
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		target       string
+		status       int
+		cacheControl string
+		etag         string
+		body         string
+	}{
+		{target: "/js/app-cc123.js", status: 200, cacheControl: ImmutableCacheControl, etag: `"cc123"`, body: `console.log("hashed")`},
+		{target: "/js/access.js", status: 200, cacheControl: RevalidateCacheControl, body: `console.log("not hashed")`},
+		{target: "/", status: 200, cacheControl: RevalidateCacheControl, body: `<script src="js/app-cc123.js"></script>`},
+		{target: "/docs/", status: 200, cacheControl: RevalidateCacheControl, body: "docs"},
+		{target: "/docs", status: 301},
+		{target: "/../dist/js/app-cc123.js", status: 404},
+		{target: "/missing.js", status: 404},
+		{target: "/" + CacheFileName, status: 404},
+		{target: "/" + JournalFileName, status: 404},
+	}
+	for _, tt := range tests {
+		w := get(tt.target, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, actual %d", tt.target, tt.status, w.Code)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, actual %q", tt.target, tt.cacheControl, cacheControl)
+		}
+		if etag := w.Header().Get("ETag"); etag == "" || tt.etag != "" && etag != tt.etag {
+			t.Errorf("%s: expected ETag %q, actual %q", tt.target, tt.etag, etag)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: expected body %q, actual %q", tt.target, tt.body, w.Body.String())
+		}
+
+		w = get(tt.target, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected %d for a matching If-None-Match, actual %d", tt.target, http.StatusNotModified, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected status %d, actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package clobber
+
+// Attributes holding a comma separated list of image candidates, each a url followed by optional descriptors.
+var srcsetAttrs = map[string]bool{
+	"srcset":      true,
+	"imagesrcset": true,
+}
+
+// Returns the url of every candidate in the srcset attr, offsets are in the scanned content like attr's own.
+// Follows the html srcset parsing rules, so urls may hold commas and descriptors may hold parenthesized commas.
+func srcsetURLs(attr tagAttr) []tagAttr {
+	urls := []tagAttr{}
+	s := attr.value
+	for i := 0; i < len(s); {
+		for i < len(s) && (isTagSpace(s[i]) || s[i] == ',') {
+			i++
+		}
+		start := i
+		for i < len(s) && !isTagSpace(s[i]) {
+			i++
+		}
+		end := i
+		for end > start && s[end-1] == ',' { // trailing commas end the candidate, there are no descriptors
+			end--
+		}
+		if end > start {
+			urls = append(urls, tagAttr{
+				name:     attr.name,
+				value:    s[start:end],
+				valStart: attr.valStart + start,
+				valEnd:   attr.valStart + end,
+			})
+		}
+		if end != i {
+			continue
+		}
+
+		depth := 0
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '(' {
+				depth++
+			} else if c == ')' && depth > 0 {
+				depth--
+			} else if c == ',' && depth == 0 {
+				i++
+				break
+			}
+		}
+	}
+	return urls
+}
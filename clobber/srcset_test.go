@@ -0,0 +1,37 @@
+package clobber
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSrcsetURLs(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{`a.png`, []string{"a.png"}},
+		{`a.png 1x, b.png 2x`, []string{"a.png", "b.png"}},
+		{"  a.png  480w ,\n\tb.png\t800w  ", []string{"a.png", "b.png"}},
+		{`a.png,b.png 2x`, []string{"a.png,b.png"}}, // a comma inside a url does not split it
+		{`a.png 1x,, b.png`, []string{"a.png", "b.png"}},
+		{`data:image/png;base64,iVBO= 1x, b.png 2x`, []string{"data:image/png;base64,iVBO=", "b.png"}},
+		{`a.png (max-width, 2x), b.png`, []string{"a.png", "b.png"}},
+		{``, []string{}},
+		{` , `, []string{}},
+	}
+	for _, tt := range tests {
+		value := `srcset="` + tt.in + `"`
+		attr := tagAttr{name: "srcset", value: tt.in, valStart: len(`srcset="`), valEnd: len(value) - 1}
+		actual := []string{}
+		for _, url := range srcsetURLs(attr) {
+			if value[url.valStart:url.valEnd] != url.value {
+				t.Errorf("srcsetURLs(%q): offsets of %q point at %q", tt.in, url.value, value[url.valStart:url.valEnd])
+			}
+			actual = append(actual, url.value)
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("srcsetURLs(%q): expected %q, actual %q", tt.in, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package clobber
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TemplateFuncs returns the html/template functions asset and sri, resolving asset paths in m at render time,
+// for html a server renders, which a Run never sees to rewrite.
+// {{asset "js/app.js"}} is urlPrefix joined with the hashed path of js/app.js, {{sri "js/app.js"}} its integrity value.
+// Paths are relative to the scanned directory, as in the manifest, with or without a leading slash.
+// Either function fails the template for a path m does not name, and sri for an asset hashed without Options.SRI.
+func TemplateFuncs(m Manifest, urlPrefix string) template.FuncMap {
+	lookup := func(original string) (ManifestEntry, error) {
+		entry, exists := m[strings.TrimPrefix(original, "/")]
+		if !exists {
+			return ManifestEntry{}, fmt.Errorf("asset %s is not in the manifest", original)
+		}
+		return entry, nil
+	}
+	return template.FuncMap{
+		"asset": func(original string) (string, error) {
+			entry, err := lookup(original)
+			if err != nil {
+				return "", err
+			}
+			if urlPrefix == "" {
+				return entry.Path, nil
+			}
+			return strings.TrimSuffix(urlPrefix, "/") + "/" + entry.Path, nil
+		},
+		"sri": func(original string) (string, error) {
+			entry, err := lookup(original)
+			if err != nil {
+				return "", err
+			}
+			if entry.Integrity == "" {
+				return "", fmt.Errorf("asset %s has no integrity value, it was hashed without an integrity algorithm", original)
+			}
+			return entry.Integrity, nil
+		},
+	}
+}
+
+// HashManifest hashes the assets under opts.Dir in memory, and returns the manifest a Run renaming them would write,
+// for serving a static directory that is not hashed ahead of time, such as an embed.FS loaded with LoadFS.
+// Every file selected by opts.Include and opts.Exclude is hashed, except html files and files already hashed,
+// with the hash settings, NameTemplate and SRI of opts. Nothing is renamed, NewManifestHandler serves the original files at the hashed paths.
+// HTML is empty in every entry, as no html is scanned.
+func HashManifest(ctx context.Context, opts Options) (Manifest, error) {
+	h, names, baseDir, err := opts.prepare()
+	if err != nil {
+		return nil, err
+	}
+	fsys := opts.fs()
+	filter, err := newPathFilter(fsys, baseDir, opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	err = fsys.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && filter.excludedDir(path) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !filter.selected(path) || isHTMLFile(path) || isToolFile(info.Name()) {
+			return nil
+		}
+		rel, err := relSlashPath(baseDir, path)
+		if err != nil {
+			return err
+		}
+		if _, ccHash := names.original(rel, h); ccHash == "" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ManifestEntry, len(paths))
+	errs := make([]error, len(paths))
+	err = forEach(ctx, opts.workers(), len(paths), func(i int) {
+		b, err := fsys.ReadFile(paths[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		asset := newHashedAsset(paths[i], b, opts, h, names)
+		dir, _ := filepath.Split(paths[i])
+		entries[i].Path, errs[i] = relSlashPath(baseDir, filepath.Join(dir, filepath.FromSlash(asset.name)))
+		entries[i].Hash = asset.ccHash
+		entries[i].Integrity = asset.integrity
+		entries[i].Size = int64(len(b))
+		entries[i].HTML = []string{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Manifest)
+	for i, path := range paths {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		rel, err := relSlashPath(baseDir, path)
+		if err != nil {
+			return nil, err
+		}
+		m[rel] = entries[i]
+	}
+	return m, nil
+}
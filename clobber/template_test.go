@@ -0,0 +1,80 @@
+package clobber
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	files := func() map[string][]byte {
+		return map[string][]byte{
+			"static/index.html":   []byte(`<script src="js/app.js"></script><link rel="stylesheet" href="site.css">`),
+			"static/js/app.js":    []byte(`console.log("app")`),
+			"static/site.css":     []byte(`body { color: red }`),
+			"static/lib-cc123.js": []byte(`console.log("hashed already")`),
+		}
+	}
+	opts := Options{Dir: "static", SRI: "sha384"}
+
+	opts.FS = NewMemFS(files())
+	m, err := HashManifest(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["js/app.js"].Integrity == "" || m["js/app.js"].Size != int64(len(`console.log("app")`)) {
+		t.Fatalf("expected js/app.js and site.css with integrity values, actual %+v", m)
+	}
+
+	// a Run writes the same manifest, html aside
+	runFS := NewMemFS(files())
+	_, err = Run(context.Background(), Options{Dir: "static", SRI: "sha384", Manifest: "manifest.json", FS: runFS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ReadManifest(runFS, "manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for original, entry := range m {
+		w := written[original]
+		if w.Path != entry.Path || w.Hash != entry.Hash || w.Integrity != entry.Integrity || w.Size != entry.Size {
+			t.Errorf("%s: expected %+v, actual %+v as a Run writes it", original, entry, w)
+		}
+	}
+
+	tmpl := template.Must(template.New("page").Funcs(TemplateFuncs(m, "/static/")).Parse(
+		`<script src="{{asset "js/app.js"}}" integrity="{{sri "/js/app.js"}}"></script>`))
+	var b strings.Builder
+	err = tmpl.Execute(&b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<script src="/static/` + m["js/app.js"].Path + `" integrity="` + m["js/app.js"].Integrity + `"></script>`
+	if b.String() != expected {
+		t.Errorf("expected %s, actual %s", expected, b.String())
+	}
+
+	missing := template.Must(template.New("page").Funcs(TemplateFuncs(m, "")).Parse(`{{asset "missing.js"}}`))
+	if err := missing.Execute(&b, nil); err == nil {
+		t.Error("expected an error for an asset missing from the manifest")
+	}
+	noSRI := template.Must(template.New("page").Funcs(TemplateFuncs(Manifest{"a.js": {Path: "a-cc1.js"}}, "")).Parse(`{{sri "a.js"}}`))
+	if err := noSRI.Execute(&b, nil); err == nil {
+		t.Error("expected an error for an asset without an integrity value")
+	}
+
+	// the hashed paths only exist in the manifest, the handler serves the original files at them
+	handler, err := NewManifestHandler(m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+m["js/app.js"].Path, nil))
+	if w.Code != 200 || w.Body.String() != `console.log("app")` || w.Header().Get("Cache-Control") != ImmutableCacheControl {
+		t.Errorf("expected js/app.js served as immutable at %s, actual status %d, body %q", m["js/app.js"].Path, w.Code, w.Body.String())
+	}
+}
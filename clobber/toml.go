@@ -0,0 +1,354 @@
+package clobber
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parses the subset of TOML a config needs: key = value pairs, [table] and [[array of tables]] headers,
+// basic and literal strings, integers, booleans, arrays and inline tables, and # comments.
+// Tables are map[string]interface{}, arrays []interface{}, integers int64.
+func parseTOML(s string) (map[string]interface{}, error) {
+	p := &tomlParser{s: s, line: 1}
+	root := make(map[string]interface{})
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.done() {
+			return root, nil
+		}
+		var err error
+		if p.peek() == '[' {
+			table, err = p.header(root)
+		} else {
+			err = p.keyValue(table)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.done() && p.peek() != '\n' {
+			return nil, p.errorf("expected a new line, found %q", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	s    string
+	i    int
+	line int
+}
+
+func (p *tomlParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.s[p.i]
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// Skips spaces, tabs and comments, and new lines too when newLines is set.
+func (p *tomlParser) skipSpace(newLines bool) {
+	for !p.done() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.i++
+		case c == '#':
+			for !p.done() && p.peek() != '\n' {
+				p.i++
+			}
+		case c == '\n' && newLines:
+			p.line++
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+// Parses a [table] or [[array of tables]] header, returning the table the following keys go in.
+func (p *tomlParser) header(root map[string]interface{}) (map[string]interface{}, error) {
+	isArray := strings.HasPrefix(p.s[p.i:], "[[")
+	if isArray {
+		p.i += 2
+	} else {
+		p.i++
+	}
+	keys, err := p.keyPath()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if isArray {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.i:], closing) {
+		return nil, p.errorf("expected %s after the table name", closing)
+	}
+	p.i += len(closing)
+
+	parent, err := p.tableAt(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	table := make(map[string]interface{})
+	switch existing := parent[last].(type) {
+	case nil:
+		if isArray {
+			parent[last] = []interface{}{table}
+		} else {
+			parent[last] = table
+		}
+	case []interface{}:
+		if !isArray {
+			return nil, p.errorf("%s is already an array of tables", last)
+		}
+		parent[last] = append(existing, table)
+	default:
+		return nil, p.errorf("%s is already defined", last)
+	}
+	return table, nil
+}
+
+// Returns the table at keys under root, creating missing ones, or the last table of an array of tables.
+func (p *tomlParser) tableAt(root map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	table := root
+	for _, key := range keys {
+		switch v := table[key].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			if len(v) == 0 {
+				return nil, p.errorf("%s is not a table", key)
+			}
+			last, isTable := v[len(v)-1].(map[string]interface{})
+			if !isTable {
+				return nil, p.errorf("%s is not a table", key)
+			}
+			table = last
+		default:
+			return nil, p.errorf("%s is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.keyPath()
+	if err != nil {
+		return err
+	}
+	if p.done() || p.peek() != '=' {
+		return p.errorf("expected = after %s", strings.Join(keys, "."))
+	}
+	p.i++
+	p.skipSpace(false)
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.tableAt(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return p.errorf("%s is defined twice", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+// Parses a dotted key of bare or quoted parts.
+func (p *tomlParser) keyPath() ([]string, error) {
+	keys := []string{}
+	for {
+		p.skipSpace(false)
+		if p.done() {
+			return nil, p.errorf("expected a key")
+		}
+		var key string
+		if c := p.peek(); c == '"' || c == '\'' {
+			k, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			key = k
+		} else {
+			start := p.i
+			for !p.done() && isTOMLBareKeyChar(p.peek()) {
+				p.i++
+			}
+			if p.i == start {
+				return nil, p.errorf("expected a key, found %q", p.peek())
+			}
+			key = p.s[start:p.i]
+		}
+		keys = append(keys, key)
+		p.skipSpace(false)
+		if p.done() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.done() {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.s[p.i:], "true"):
+		p.i += len("true")
+		return true, nil
+	case strings.HasPrefix(p.s[p.i:], "false"):
+		p.i += len("false")
+		return false, nil
+	}
+
+	start := p.i
+	for !p.done() && strings.IndexByte("+-_0123456789", p.peek()) != -1 {
+		p.i++
+	}
+	n, err := strconv.ParseInt(strings.Replace(p.s[start:p.i], "_", "", -1), 10, 64)
+	if p.i == start || err != nil {
+		return nil, p.errorf("unsupported value %q", p.s[start:p.i]+p.restOfLine())
+	}
+	return n, nil
+}
+
+func (p *tomlParser) restOfLine() string {
+	end := strings.IndexByte(p.s[p.i:], '\n')
+	if end == -1 {
+		return p.s[p.i:]
+	}
+	return p.s[p.i : p.i+end]
+}
+
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.i++ // [
+	arr := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.done() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.i++
+			return arr, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		p.skipSpace(true)
+		if !p.done() && p.peek() == ',' {
+			p.i++
+		} else if p.done() || p.peek() != ']' {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.i++ // {
+	table := make(map[string]interface{})
+	p.skipSpace(false)
+	if !p.done() && p.peek() == '}' {
+		p.i++
+		return table, nil
+	}
+	for {
+		err := p.keyValue(table)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.done() {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.peek() {
+		case ',':
+			p.i++
+		case '}':
+			p.i++
+			return table, nil
+		default:
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// Parses a single line basic "string" with escapes, or a 'literal string'.
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	p.i++
+	var b strings.Builder
+	for {
+		if p.done() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.i++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if p.done() {
+				return "", p.errorf("unterminated string")
+			}
+			esc := p.peek()
+			p.i++
+			switch esc {
+			case '"', '\\':
+				b.WriteByte(esc)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u', 'U':
+				size := 4
+				if esc == 'U' {
+					size = 8
+				}
+				if p.i+size > len(p.s) {
+					return "", p.errorf("short unicode escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.i:p.i+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("invalid unicode escape %q", p.s[p.i:p.i+size])
+				}
+				b.WriteRune(rune(r))
+				p.i += size
+			default:
+				return "", p.errorf("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
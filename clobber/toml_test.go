@@ -0,0 +1,48 @@
+package clobber
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		expected map[string]interface{}
+	}{
+		{"empty", "# nothing\n\n", map[string]interface{}{}},
+		{"scalars", "a = \"x\\ty\\u00e9\"\nb = 'C:\\dir'\nc = 1_000\nd = -2\ne = true\n", map[string]interface{}{
+			"a": "x\tyé", "b": `C:\dir`, "c": int64(1000), "d": int64(-2), "e": true,
+		}},
+		{"quoted and dotted keys", "\"a b\" = 1\nc.d = 2", map[string]interface{}{
+			"a b": int64(1), "c": map[string]interface{}{"d": int64(2)},
+		}},
+		{"multiline array", "a = [\n  1, # one\n  [2, 3],\n]", map[string]interface{}{
+			"a": []interface{}{int64(1), []interface{}{int64(2), int64(3)}},
+		}},
+		{"tables", "[t]\na = 1\n[[r]]\nb = 2\n[[r]]\nb = 3 # last", map[string]interface{}{
+			"t": map[string]interface{}{"a": int64(1)},
+			"r": []interface{}{map[string]interface{}{"b": int64(2)}, map[string]interface{}{"b": int64(3)}},
+		}},
+		{"inline table", `a = { b = "c", d = [] }`, map[string]interface{}{
+			"a": map[string]interface{}{"b": "c", "d": []interface{}{}},
+		}},
+	}
+	for _, tt := range tests {
+		actual, err := parseTOML(tt.in)
+		if err != nil {
+			t.Errorf("%s: errored %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: expected %v, actual %v", tt.name, tt.expected, actual)
+		}
+	}
+
+	for _, in := range []string{"a", "a = ", "a = 1 b = 2", "a = 1\na = 2", "a = \"x", "a = [1 2]", "[t", "a = 1.5", "a = \"\\q\"", "x = []\n[x.y]", "x = []\nx.y = 1"} {
+		if _, err := parseTOML(in); err == nil {
+			t.Errorf("parseTOML(%q): expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package clobber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch polls for changes when not told otherwise.
+const DefaultWatchInterval = 500 * time.Millisecond
+
+// WatchCycle is a run of Watch and the changes that caused it.
+type WatchCycle struct {
+	Changed  []string // files added, modified or removed under Dir since the last run, none on the first
+	Result   *Result
+	Started  time.Time
+	Duration time.Duration
+}
+
+// Print writes a line summing up c to w, followed by its errors.
+func (c WatchCycle) Print(w io.Writer) {
+	cause := "first run"
+	if len(c.Changed) == 1 {
+		cause = c.Changed[0] + " changed"
+	} else if len(c.Changed) > 1 {
+		cause = fmt.Sprintf("%d files changed", len(c.Changed))
+	}
+	fmt.Fprintf(w, "[%s] %s: %d rewritten, %d hashed, %d errors in %s\n", c.Started.Format("15:04:05"), cause,
+		len(c.Result.Edits), len(c.Result.Renames), c.Result.errorCount(), c.Duration.Round(time.Millisecond))
+
+	files := make([]string, 0, len(c.Result.Errors))
+	for file := range c.Result.Errors {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		for _, err := range c.Result.Errors[file] {
+			fmt.Fprintf(w, "  %s\n", err.Error())
+		}
+	}
+}
+
+// Watch does a Run, then runs again whenever files under opts.Dir change, until ctx is done.
+// opts.Dir is polled every interval, DefaultWatchInterval when 0, and a run starts once a poll sees no further change,
+// so a burst of changes causes a single run. Directories opts.Exclude skips are not watched.
+// Runs after the first only rewrite the html files that changed or reference a changed file, directly or through css,
+// and copy the other changed files, with the hashes of unchanged assets taken from opts.Cache when it is set.
+// opts.OutDir must be set, so files are never renamed while they are edited. onCycle is called after every run.
+// Returns ctx's error once it is done, or an error for invalid options.
+func Watch(ctx context.Context, opts Options, interval time.Duration, onCycle func(WatchCycle)) error {
+	if opts.OutDir == "" {
+		return errors.New("watch needs an output directory, so files are not renamed while they are edited")
+	}
+	_, _, baseDir, err := opts.prepare()
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	w := &watcher{opts: opts, baseDir: baseDir, references: make(map[string][]string)}
+	w.filter, err = newPathFilter(opts.fs(), w.baseDir, opts.Include, opts.Exclude)
+	if err != nil {
+		return err
+	}
+
+	files, err := w.snapshot()
+	if err != nil {
+		return err
+	}
+	err = w.run(ctx, nil, files, onCycle)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	changed := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		next, err := w.snapshot()
+		if err != nil {
+			continue // mid change, like a directory being replaced, polled again next tick
+		}
+		changes := changedFiles(files, next)
+		files = next
+		for _, path := range changes {
+			changed[path] = true
+		}
+		if len(changes) > 0 || len(changed) == 0 {
+			continue // runs once changes settle
+		}
+		err = w.run(ctx, changed, files, onCycle)
+		if err != nil {
+			return err
+		}
+		changed = make(map[string]bool)
+	}
+}
+
+// State Watch keeps between runs.
+type watcher struct {
+	opts       Options
+	baseDir    string
+	filter     *pathFilter
+	references map[string][]string // [html or css file]files it references, as of the last run scanning it
+}
+
+// Size and modification time of a file, which change when it is written.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// Returns the stamp of every file under the watched directory, except for those a run writes itself.
+func (w *watcher) snapshot() (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	written := map[string]bool{
+		filepath.Clean(w.opts.Journal):  true,
+		filepath.Clean(w.opts.Manifest): true,
+		filepath.Clean(w.opts.Cache):    true,
+	}
+	err := w.opts.fs().Walk(w.baseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if samePath(path, w.opts.OutDir) || w.filter.excludedDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == CacheFileName || info.Name() == JournalFileName || written[filepath.Clean(path)] {
+			return nil
+		}
+		files[filepath.Clean(path)] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// Returns the files added, modified or removed between the snapshots before and after, sorted.
+func changedFiles(before, after map[string]fileStamp) []string {
+	changed := []string{}
+	for path, stamp := range after {
+		if previous, exists := before[path]; !exists || previous.size != stamp.size || !previous.modTime.Equal(stamp.modTime) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Runs for the changed files, which exist when they are in files, or over the whole directory when changed is nil.
+func (w *watcher) run(ctx context.Context, changed map[string]bool, files map[string]fileStamp, onCycle func(WatchCycle)) error {
+	cycle := WatchCycle{Changed: []string{}, Started: time.Now()}
+	var scope *runScope
+	if changed != nil {
+		scope = &runScope{htmlFiles: w.affectedHTML(changed, files), copy: make(map[string]bool)}
+		for path := range changed {
+			cycle.Changed = append(cycle.Changed, path)
+			if _, exists := files[path]; exists {
+				scope.copy[path] = true
+				continue
+			}
+			if outPath, err := mirrorPath(w.baseDir, w.opts.OutDir, path); err == nil {
+				w.opts.fs().Remove(outPath) // hashed copies are left to Prune
+			}
+		}
+		sort.Strings(cycle.Changed)
+	}
+
+	result, graph, err := runScoped(ctx, w.opts, scope)
+	if err != nil {
+		return err
+	}
+	for path := range graph.scanned {
+		paths := []string{}
+		for _, ref := range graph.refs[path] {
+			paths = append(paths, ref.path)
+		}
+		w.references[path] = paths
+	}
+
+	cycle.Result = result
+	cycle.Duration = time.Since(cycle.Started)
+	if onCycle != nil {
+		onCycle(cycle)
+	}
+	return nil
+}
+
+// Returns the existing html files in changed, and those referencing a file in changed, directly or through css.
+func (w *watcher) affectedHTML(changed map[string]bool, files map[string]fileStamp) []string {
+	referencedBy := make(map[string][]string)
+	for from, paths := range w.references {
+		for _, path := range paths {
+			referencedBy[path] = append(referencedBy[path], from)
+		}
+	}
+
+	html := []string{}
+	seen := make(map[string]bool)
+	queue := []string{}
+	for path := range changed {
+		queue = append(queue, path)
+	}
+	for len(queue) > 0 {
+		path := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if _, exists := files[path]; exists && isHTMLFile(path) && w.filter.selected(path) {
+			html = append(html, path)
+		}
+		queue = append(queue, referencedBy[path]...)
+	}
+	sort.Strings(html)
+	return html
+}
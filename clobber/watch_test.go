@@ -0,0 +1,94 @@
+package clobber
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	fsys := NewMemFS(map[string][]byte{
+		"site/index.html":   []byte(`<link rel="stylesheet" href="css/site.css"><script src="app.js"></script>`),
+		"site/about.html":   []byte(`<script src="about.js"></script>`),
+		"site/app.js":       []byte(`console.log("app")`),
+		"site/about.js":     []byte(`console.log("about")`),
+		"site/css/site.css": []byte(`body{background:url("../img/bg.png")}`),
+		"site/img/bg.png":   []byte("png"),
+		"site/robots.txt":   []byte("User-agent: *"),
+	})
+	opts := Options{Dir: "site", OutDir: "dist", Cache: "site/" + CacheFileName, FS: fsys}
+	ctx, cancel := context.WithCancel(context.Background())
+	cycles := make(chan WatchCycle)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, opts, 5*time.Millisecond, func(c WatchCycle) { cycles <- c })
+	}()
+	next := func() WatchCycle {
+		t.Helper()
+		select {
+		case c := <-cycles:
+			if c.Result.errorCount() != 0 {
+				t.Errorf("expected no errors, actual %v", c.Result.Errors)
+			}
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("no run after 5s")
+			return WatchCycle{}
+		}
+	}
+	rewritten := func(c WatchCycle) string {
+		files := []string{}
+		for file := range c.Result.Edits {
+			files = append(files, file)
+		}
+		return strings.Join(sortedStrings(files), " ")
+	}
+
+	first := next()
+	if len(first.Changed) != 0 || rewritten(first) != "site/about.html site/css/site.css site/index.html" {
+		t.Errorf("first run: expected every file rewritten, actual %s for changes %v", rewritten(first), first.Changed)
+	}
+
+	fsys.WriteFile("site/img/bg.png", []byte("new png"))
+	c := next()
+	if strings.Join(c.Changed, " ") != "site/img/bg.png" || rewritten(c) != "site/css/site.css site/index.html" {
+		t.Errorf("image change: expected the css and the html using it rewritten, actual %s for changes %v", rewritten(c), c.Changed)
+	}
+	index, _ := fsys.ReadFile("dist/index.html")
+	for _, rename := range c.Result.Renames {
+		if rename.From == "site/css/site.css" && !strings.Contains(string(index), strings.TrimPrefix(rename.To, "site/")) {
+			t.Errorf("expected dist/index.html to reference %s: %s", rename.To, index)
+		}
+	}
+
+	fsys.WriteFile("site/robots.txt", []byte("User-agent: bot"))
+	fsys.WriteFile("site/new.html", []byte(`<script src="about.js"></script>`))
+	c = next()
+	if strings.Join(c.Changed, " ") != "site/new.html site/robots.txt" || rewritten(c) != "site/new.html" {
+		t.Errorf("burst: expected one run rewriting new.html, actual %s for changes %v", rewritten(c), c.Changed)
+	}
+	if b, _ := fsys.ReadFile("dist/robots.txt"); string(b) != "User-agent: bot" {
+		t.Errorf("expected the changed robots.txt copied, actual %q", b)
+	}
+
+	fsys.Remove("site/robots.txt")
+	next()
+	if _, err := fsys.Stat("dist/robots.txt"); err == nil {
+		t.Error("expected the removed robots.txt removed from dist")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected %v once cancelled, actual %v", context.Canceled, err)
+	}
+	if err := Watch(context.Background(), Options{Dir: "site", FS: fsys}, 0, nil); err == nil {
+		t.Error("expected an error watching without an output directory")
+	}
+}
+
+func sortedStrings(arr []string) []string {
+	sort.Strings(arr)
+	return arr
+}
@@ -1,4 +1,4 @@
 module cache-clobber
 
-go 1.14
+go 1.16
 
@@ -1,401 +1,352 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
-	"hash/crc32"
-	"io"
-	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+
+	"cache-clobber/clobber"
 )
 
 func main() {
-	baseDir := flag.String("dir", "./", "specifies the directory to scan recursively in for html files")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			restoreMain(os.Args[2:])
+			return
+		case "prune":
+			pruneMain(os.Args[2:])
+			return
+		case "watch":
+			watchMain(os.Args[2:])
+			return
+		case "serve":
+			serveMain(os.Args[2:])
+			return
+		}
+	}
 
+	runFlags := addRunFlags(flag.CommandLine)
+	dryRun := flag.Bool("dry-run", false, "prints the renames and html edits that would be made without changing any files")
 	flag.Parse()
 
-	changes := appendHashes(*baseDir)
-	changes.printChangesErrors()
+	all, err := runFlags.options(flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, opts := range all {
+		opts.DryRun = *dryRun
+		result, err := clobber.Run(context.Background(), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result.Print(os.Stdout)
+	}
 }
 
-type changes struct {
-	edits  map[string][]edit // [htmlFile]edits
-	errors map[string][]editError
+// Flags of a run, shared by the default command and watch.
+type runFlags struct {
+	*namingFlags
+	configFile, baseDir, manifest, outDir, journal, cache *string
+	sri, crossOrigin                                      *string
+	jobs                                                  *int
+	noCache                                               *bool
 }
 
-type edit struct {
-	fileNameFrom string
-	fileNameTo   string
-	htmlFile     string
+func addRunFlags(flags *flag.FlagSet) *runFlags {
+	f := &runFlags{namingFlags: addNamingFlags(flags)}
+	f.configFile = flags.String("config", "", "config file to read, defaults to "+strings.Join(clobber.ConfigFileNames, " or ")+" in the working directory, flags override it")
+	f.baseDir = flags.String("dir", "./", "specifies the directory to scan recursively in for html files")
+	f.manifest = flags.String("manifest", "", "writes a json manifest of original paths to hashed paths to this file")
+	f.outDir = flags.String("out", "", "mirrors -dir into this directory with hashed copies of assets, leaving -dir untouched")
+	f.journal = flags.String("journal", "", "journal of runs used by restore, defaults to "+clobber.JournalFileName+" in -dir")
+	f.cache = flags.String("cache", "", "cache of asset hashes, so unchanged assets are not hashed again, defaults to "+clobber.CacheFileName+" in -out, or -dir without it")
+	f.noCache = flags.Bool("no-cache", false, "hashes every asset, neither reading nor writing the cache")
+	f.jobs = flags.Int("jobs", 0, "files to read, hash and write at once, one per cpu when 0")
+	f.sri = flags.String("sri", "", "adds integrity attributes to script and link tags using sha256, sha384 or sha512")
+	f.crossOrigin = flags.String("crossorigin", "", "crossorigin value added to tags given an integrity attribute, e.g. anonymous")
+	return f
 }
 
-type editError struct {
-	err      error
-	htmlFile string
+// Flags selecting files and naming hashed ones, shared by runs and prune, so prune recognises hashed names as a run gave them.
+type namingFlags struct {
+	hashAlgorithm, hashEncoding, nameTemplate *string
+	hashLength                                *int
+	include, exclude, ruleSpecs, legacyHashes stringsFlag
 }
 
-func (c *changes) addEdit(htmlFile, nameFrom, nameTo string) {
-	if _, exists := c.edits[htmlFile]; !exists {
-		c.edits[htmlFile] = make([]edit, 0, 1)
-	}
-	arr := c.edits[htmlFile]
-	arr = append(arr, edit{
-		fileNameFrom: nameFrom,
-		fileNameTo:   nameTo,
-		htmlFile:     htmlFile,
-	})
-	c.edits[htmlFile] = arr
+func addNamingFlags(flags *flag.FlagSet) *namingFlags {
+	f := &namingFlags{}
+	f.hashAlgorithm = flags.String("hash", "crc32", "hash algorithm, one of sha256, sha1, md5, crc32 or fnv64")
+	f.hashEncoding = flags.String("hash-encoding", "decimal", "hash encoding, one of decimal, hex, base32 or base64url")
+	f.hashLength = flags.Int("hash-length", 0, "characters of the encoded hash to keep, all when 0")
+	flags.Var(&f.legacyHashes, "legacy-hash", "algorithm/encoding of earlier runs, e.g. crc32/hex, whose full hashes are recognised and replaced too, repeatable")
+	f.nameTemplate = flags.String("name-template", clobber.DefaultNameTemplate, "names of hashed files from {dir}, {name}, {hash} and {ext}, e.g. {name}.{hash}{ext} or {hash}/{name}{ext}")
+	flags.Var(&f.include, "include", "glob of html files and assets to select, e.g. public/**, repeatable")
+	flags.Var(&f.exclude, "exclude", "glob of html files, assets and directories to skip, e.g. node_modules, repeatable")
+	flags.Var(&f.ruleSpecs, "rule", "tag[attr] references to hash, e.g. img[src]:.png,.jpg or link[href][rel=icon], default or extended, repeatable, replaces the default rules")
+	return f
 }
 
-func (c *changes) addError(htmlFile string, err error) {
-	if _, exists := c.errors[htmlFile]; !exists {
-		c.errors[htmlFile] = make([]editError, 0, 1)
+// Overrides config with the naming flags set on the parsed flags.
+func (f *namingFlags) override(config *clobber.Config, flags *flag.FlagSet) error {
+	rules, err := clobber.ParseRules(f.ruleSpecs)
+	if err != nil {
+		return err
 	}
-	arr := c.errors[htmlFile]
-	arr = append(arr, editError{
-		err:      err,
-		htmlFile: htmlFile,
+	flags.Visit(func(set *flag.Flag) {
+		switch set.Name {
+		case "hash":
+			config.Hash = *f.hashAlgorithm
+		case "hash-encoding":
+			config.HashEncoding = *f.hashEncoding
+		case "hash-length":
+			config.HashLength = *f.hashLength
+		case "legacy-hash":
+			config.LegacyHashes = f.legacyHashes
+		case "name-template":
+			config.NameTemplate = *f.nameTemplate
+		case "include":
+			config.Include = f.include
+		case "exclude":
+			config.Exclude = f.exclude
+		case "rule":
+			config.Rules = rules
+		}
 	})
-	c.errors[htmlFile] = arr
+	return nil
 }
 
-func (c *changes) printChangesErrors() {
-	if len(c.edits) == 0 {
-		fmt.Println("No changes.")
+// Returns the options of a run in each root of the config, with the flags set on the parsed flags overriding it.
+func (f *runFlags) options(flags *flag.FlagSet) ([]clobber.Options, error) {
+	config, err := loadConfig(*f.configFile)
+	if err != nil {
+		return nil, err
 	}
-	for html, arr := range c.edits {
-		if len(arr) == 0 {
-			fmt.Println()
-			continue
-		}
-		for _, edit := range arr {
-			_, fFrom := filepath.Split(edit.fileNameFrom)
-			_, fTo := filepath.Split(edit.fileNameTo)
-			fmt.Printf("\n[%s] %s => %s", html, fFrom, fTo)
-		}
+	err = f.override(config, flags)
+	if err != nil {
+		return nil, err
 	}
-
-	for html, arr := range c.errors {
-		if len(arr) == 0 {
-			fmt.Println()
-			continue
-		}
-		for _, edit := range arr {
-			fmt.Printf("\n[%s] ERROR: %s", html, edit.err.Error())
+	flags.Visit(func(set *flag.Flag) {
+		switch set.Name {
+		case "dir":
+			config.Roots = []string{*f.baseDir}
+		case "manifest":
+			config.Manifest = *f.manifest
+		case "out":
+			config.Out = *f.outDir
+		case "journal":
+			config.Journal = *f.journal
+		case "cache":
+			config.Cache = *f.cache
+		case "no-cache":
+			config.NoCache = *f.noCache
+		case "jobs":
+			config.Jobs = *f.jobs
+		case "sri":
+			config.SRI = *f.sri
+		case "crossorigin":
+			config.CrossOrigin = *f.crossOrigin
 		}
-	}
-}
-
-func appendHashes(baseDir string) *changes {
-	changes := &changes{
-		edits:  make(map[string][]edit),
-		errors: make(map[string][]editError),
-	}
-
-	htmlFilePaths, err := htmlFilePaths(baseDir)
+	})
+	err = config.Validate()
 	if err != nil {
-		changes.addError("", err)
-		return changes
+		return nil, err
 	}
 
-	var editJobs = []*job{}
-	for _, filePath := range htmlFilePaths {
-		b, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			log.Fatal(err)
+	all := config.Options()
+	for i, opts := range all {
+		opts.Journal = pathOrDefault(opts.Journal, opts.Dir, clobber.JournalFileName)
+		if !config.NoCache {
+			cacheDir := opts.Dir
+			if opts.OutDir != "" {
+				cacheDir = opts.OutDir // -dir is left untouched
+			}
+			opts.Cache = pathOrDefault(opts.Cache, cacheDir, clobber.CacheFileName)
 		}
-		addEditJobs(changes, &editJobs, filePath, string(b))
+		all[i] = opts
 	}
-	renameAll(changes, editJobs)
-	return changes
+	return all, nil
 }
 
-func htmlFilePaths(baseDir string) ([]string, error) {
-	var htmlFilePaths []string
-	err := filepath.Walk(baseDir,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+// Runs again whenever files change, see clobber.Watch.
+func watchMain(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	runFlags := addRunFlags(flags)
+	interval := flags.Duration("interval", clobber.DefaultWatchInterval, "how often -dir is polled for changes")
+	flags.Parse(args)
 
-			split := strings.Split(info.Name(), ".")
-			if len(split) > 0 {
-				ext := split[len(split)-1]
-				if ext == "html" || ext == "htm" {
-					htmlFilePaths = append(htmlFilePaths, path)
-				}
-			}
-			return nil
-		})
+	all, err := runFlags.options(flags)
 	if err != nil {
-		return nil, nil
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = clobber.Watch(ctx, all[0], *interval, func(cycle clobber.WatchCycle) { // there is a single root, as -out is required
+		cycle.Print(os.Stdout)
+	})
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
 	}
-	return htmlFilePaths, nil
-}
-
-type job struct {
-	fileNameWantToRename string
-	filePathWantToRename string
-	renameTo             string
-	tagPath              string
-	wholeTag             string
-	htmlFile             string
 }
 
-type renameJob struct {
-	pathFrom string
-	pathTo   string
-	htmlFile string
-}
+// Serves the hashed tree with cache headers, see clobber.FileServer, rebuilding it on changes with -watch.
+func serveMain(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	runFlags := addRunFlags(flags)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	watch := flags.Bool("watch", false, "runs from -dir into -out first, and again whenever files under -dir change")
+	interval := flags.Duration("interval", clobber.DefaultWatchInterval, "how often -dir is polled for changes with -watch")
+	flags.Parse(args)
 
-func renameAll(changes *changes, jobs []*job) {
-	for _, job := range jobs {
-		job.filePathWantToRename = filepath.Clean(job.filePathWantToRename)
+	all, err := runFlags.options(flags)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	renameJobs := make(map[string]renameJob)
-	for _, job := range jobs {
-		dir, _ := filepath.Split(job.filePathWantToRename)
-		renameJobs[job.filePathWantToRename] = renameJob{
-			pathFrom: job.filePathWantToRename,
-			pathTo:   dir + job.renameTo,
-			htmlFile: job.htmlFile,
-		}
-	} // only want to rename a file once, else we may attempt to rename an already renamed file, and it won't exist
-
-	for _, job := range renameJobs {
-		err := os.Rename(job.pathFrom, job.pathTo)
-		if err != nil {
-			changes.addError(job.htmlFile, err)
-		}
+	if len(all) > 1 {
+		log.Fatalf("serve needs a single root, there are %d", len(all))
 	}
-
-	// batch html edits
-	for _, job := range jobs {
-		fileContent, err := ioutil.ReadFile(job.htmlFile)
-		if err != nil {
-			changes.addError(job.htmlFile, err)
-		}
-
-		newFileContent := strings.ReplaceAll(string(fileContent), job.wholeTag, newTag(job))
-		err = ioutil.WriteFile(job.htmlFile, []byte(newFileContent), 0644)
-		if err != nil {
-			changes.addError(job.htmlFile, err)
-			continue
-		}
-		changes.addEdit(job.htmlFile, job.filePathWantToRename, job.renameTo)
+	opts := all[0]
+	if *watch && opts.OutDir == "" {
+		fmt.Fprintln(flags.Output(), "serve -watch needs -out, so files are not renamed while they are edited")
+		flags.Usage()
+		os.Exit(2)
 	}
-}
-
-func newTag(j *job) string {
-	newTag := strings.ReplaceAll(j.wholeTag, j.tagPath+j.fileNameWantToRename, j.tagPath+j.renameTo)
-	return strings.ReplaceAll(j.wholeTag, j.wholeTag, newTag)
-}
-
-type tagInfo struct {
-	tagType  string
-	wholeTag string
-	startTag int
-}
-
-func addEditJobs(editsErrors *changes, jobs *[]*job, htmlFilePath, fileContent string) {
-	dir, _ := filepath.Split(htmlFilePath)
-	tags := tagsFromHTML(fileContent)
-	for _, ti := range tags {
-		if ti.tagType == "script" {
-			src, err := srcFilePath(ti.wholeTag)
-			if err != nil && err.Error() == "src is empty" || httpPrefixed(src) {
-				continue // normal for script tags to not have srcs
-			}
-			if err != nil {
-				editsErrors.addError(htmlFilePath, err)
-				continue
-			}
-			addJob(editsErrors, jobs, dir, src, htmlFilePath, ti.wholeTag)
-		}
-		if ti.tagType == "link" {
-			href, err := hrefFilePath(ti.wholeTag)
-			if httpPrefixed(href) {
-				continue
-			}
-			if err != nil {
-				if err.Error() != "href is empty" && err.Error() != "href is not css file" {
-					editsErrors.addError(htmlFilePath, err)
-				}
-				continue
-			}
-			addJob(editsErrors, jobs, dir, href, htmlFilePath, ti.wholeTag)
-		}
+	handler, err := clobber.FileServer(opts)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
 
-func httpPrefixed(s string) bool {
-	prefix := "http"
-	if len(s) < len(prefix) {
-		return false
+	if *watch {
+		go func() {
+			err := clobber.Watch(context.Background(), opts, *interval, func(cycle clobber.WatchCycle) {
+				cycle.Print(os.Stdout)
+			})
+			log.Fatal(err)
+		}()
 	}
-	if s[:4] == prefix {
-		return true
+	root := opts.OutDir
+	if root == "" {
+		root = opts.Dir
 	}
-	return false
+	log.Printf("serving %s on http://%s", root, *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
 }
 
-func addJob(changes *changes, jobs *[]*job, dir string, srcHref string, htmlFilePath string, wholeTag string) {
-	hashedFileName, err := getHashedFileName(dir + srcHref) // change to rename file
-	if err != nil {
-		changes.addError(htmlFilePath, err)
-		return
-	}
-
-	_, originalName := filepath.Split(dir + srcHref)
-	tagLocalPath, _ := filepath.Split(srcHref)
-	*jobs = append(*jobs, &job{
-		fileNameWantToRename: originalName,
-		filePathWantToRename: dir + srcHref,
-		renameTo:             hashedFileName,
-		tagPath:              tagLocalPath,
-		htmlFile:             htmlFilePath,
-		wholeTag:             wholeTag,
-	})
-}
+// Undoes every run recorded in the journal, see clobber.Restore.
+func restoreMain(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile := flags.String("config", "", "config file to read the roots and journal from, defaults to "+strings.Join(clobber.ConfigFileNames, " or ")+" in the working directory")
+	baseDir := flags.String("dir", "./", "specifies the directory a previous run hashed")
+	journal := flags.String("journal", "", "journal of runs to undo, defaults to "+clobber.JournalFileName+" in -dir")
+	flags.Parse(args)
 
-// Renames file at filePath with a cache clobber certified hash.
-// Returns a newly renamed filepath.
-// Will remove the previous cc hash if it exists.
-func getHashedFileName(filePath string) (string, error) {
-	clean := filepath.Clean(filePath)
-	b, err := ioutil.ReadFile(clean)
+	config, err := loadConfig(*configFile)
 	if err != nil {
-		return "", err
+		log.Fatal(err)
 	}
-	ccHash := "cc" + fmt.Sprint(hash(string(b))) // cc for CACHE CLOBBER
-	_, fileName := filepath.Split(filePath)
-
-	splitAtDash := strings.Split(fileName, "-")
-	hashAndExt := splitAtDash[len(splitAtDash)-1]
-	splitAtDot := strings.Split(hashAndExt, ".")
-	possibleHash := splitAtDot[len(splitAtDot)-2]
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dir":
+			config.Roots = []string{*baseDir}
+		case "journal":
+			config.Journal = *journal
+		}
+	})
 
-	if isCCHash(possibleHash) {
-		newFileName := strings.Replace(fileName, possibleHash, ccHash, 1)
-		return newFileName, nil
+	for _, opts := range config.Options() {
+		result, err := clobber.Restore(context.Background(), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result.Print(os.Stdout)
 	}
-
-	ext := filepath.Ext(filePath)
-	newFileName := fileName[:len(fileName)-len(ext)] + "-" + ccHash + ext
-	return newFileName, nil
 }
 
-func isCCHash(s string) bool {
-	if len(s) < 3 { //"cc#" is minimum
-		return false
+// Removes stale generations of hashed assets, see clobber.Prune.
+func pruneMain(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	configFile := flags.String("config", "", "config file to read settings from, defaults to "+strings.Join(clobber.ConfigFileNames, " or ")+" in the working directory, flags override it")
+	baseDir := flags.String("dir", "./", "specifies the directory previous runs hashed in place")
+	outDir := flags.String("out", "", "directory previous runs mirrored -dir into, pruned instead of -dir")
+	manifest := flags.String("manifest", "", "json manifest whose hashed paths are kept")
+	keep := flags.Int("keep", 2, "newest generations of each hashed asset to keep, referenced or not")
+	dryRun := flags.Bool("dry-run", false, "prints the files that would be removed without removing them")
+	naming := addNamingFlags(flags)
+	flags.Parse(args)
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if s[:2] != "cc" {
-		return false
+	err = naming.override(config, flags)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return true
-}
-
-func hash(s string) uint32 {
-	hasher := crc32.New(crc32.IEEETable)
-	_, err := io.WriteString(hasher, s)
+	if config.Keep == nil {
+		config.Keep = keep
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "keep":
+			config.Keep = keep
+		case "dir":
+			config.Roots = []string{*baseDir}
+		case "out":
+			config.Out = *outDir
+		case "manifest":
+			config.Manifest = *manifest
+		}
+	})
+	err = config.Validate()
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal(err)
 	}
-	return hasher.Sum32()
-}
 
-// Runs func op for each tag found in fileContent.
-func tagsFromHTML(fileContent string) []tagInfo {
-	tags := make([]tagInfo, 0, 0)
-	insideTag := false
-	readTagType := false // read = past tense
-
-	startTag := 0
-	currTagType := ""
-	for i, char := range fileContent {
-		if char == '<' {
-			startTag = i
-			insideTag = true
-			continue
-		}
-		if char == '>' {
-			wholeTag := fileContent[startTag : i+1]
-			tags = append(tags, tagInfo{
-				tagType:  currTagType,
-				wholeTag: wholeTag,
-				startTag: startTag,
-			})
-			currTagType = ""
-			readTagType = false
-			insideTag = false
-			continue
-		}
-		if insideTag {
-			if char == ' ' && !readTagType {
-				currTagType = fileContent[startTag+1 : i] // startTag+1 cuts off the <
-				readTagType = true
-			}
-			if !readTagType {
-				currTagType = currTagType + string(char)
-			}
+	for _, opts := range config.Options() {
+		opts.DryRun = *dryRun
+		result, err := clobber.Prune(context.Background(), opts)
+		if err != nil {
+			log.Fatal(err)
 		}
+		result.Print(os.Stdout)
 	}
-	return tags
 }
 
-func hrefFilePath(wholeTag string) (string, error) {
-	start := strings.Index(wholeTag, `href="`)
-	quoteType := '"'
-	if start == -1 {
-		start = strings.Index(wholeTag, `href='`)
-		quoteType = '\''
-	}
-	start += len(`href="`)
-	filePath := ""
-	for i := start; i < len(wholeTag); i++ {
-		if rune(wholeTag[i]) == quoteType {
-			filePath = wholeTag[start:i]
-			break
+// Returns the config at configFile, else the one in the working directory, else an empty config.
+func loadConfig(configFile string) (*clobber.Config, error) {
+	if configFile == "" {
+		var err error
+		configFile, err = clobber.FindConfig(".")
+		if err != nil || configFile == "" {
+			return &clobber.Config{}, err
 		}
 	}
+	return clobber.LoadConfig(configFile)
+}
 
-	if filePath == "" {
-		return "", errors.New("href is empty")
-	}
-
-	if !strings.Contains(filePath, ".css") {
-		return "", errors.New("href is not css file")
+// Returns path, or the file fileName in baseDir when it is empty.
+func pathOrDefault(path, baseDir, fileName string) string {
+	if path != "" {
+		return path
 	}
-	return filePath, nil // cuts off href=" and "
+	return filepath.Join(baseDir, fileName)
 }
 
-func srcFilePath(wholeTag string) (string, error) {
-	start := strings.Index(wholeTag, `src="`)
-	quoteType := '"'
-	if start == -1 {
-		start = strings.Index(wholeTag, `src='`)
-		quoteType = '\''
-	}
-	start += len(`src="`)
-	filePath := ""
-	for i := start; i < len(wholeTag); i++ {
-		if rune(wholeTag[i]) == quoteType {
-			filePath = wholeTag[start:i]
-			break
-		}
-	}
+// Flag collecting every value it is given.
+type stringsFlag []string
 
-	if filePath == "" {
-		return "", errors.New("src is empty")
-	}
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
 
-	if !strings.Contains(filePath, ".js") {
-		return "", errors.New("src is not js file")
-	}
-	return filePath, nil // cuts off src=" and "
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }